@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+// Common subset of the compared caches.
+type benchCache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+}
+
+type cacheAdapter struct {
+	get func(key string) (interface{}, bool)
+	set func(key string, value interface{}, expire time.Time)
+}
+
+func (c cacheAdapter) Get(key string) (interface{}, bool) { return c.get(key) }
+func (c cacheAdapter) Set(key string, value interface{})  { c.set(key, value, time.Time{}) }
+
+// Unbounded, the best a cache could hope for.
+type syncMap struct{ sync.Map }
+
+func (m *syncMap) Get(key string) (interface{}, bool) { return m.Load(key) }
+func (m *syncMap) Set(key string, value interface{})  { m.Store(key, value) }
+
+var benchCaches = []struct {
+	name string
+	make func(capacity int) benchCache
+}{
+	{"LRUCache", func(capacity int) benchCache {
+		c := lrucache.NewLRUCache(uint(capacity))
+		return cacheAdapter{c.Get, c.Set}
+	}},
+	{"LRUCacheBuffered", func(capacity int) benchCache {
+		c := lrucache.NewLRUCacheOptions(uint(capacity), lrucache.Options{BufferedReads: true})
+		return cacheAdapter{c.Get, c.Set}
+	}},
+	{"MultiLRUCache", func(capacity int) benchCache {
+		c := multilru.NewMultiLRUCache(16, uint(capacity+15)/16)
+		return cacheAdapter{c.Get, c.Set}
+	}},
+	{"SyncMap", func(capacity int) benchCache {
+		return &syncMap{}
+	}},
+}
+
+// Pregenerated accesses, replayed in a loop.
+const benchOps = 1 << 16
+
+type benchWorkload struct {
+	keys  []string // key of every access
+	reads []bool   // is the access a Get
+	value interface{}
+}
+
+// Keys are drawn from four times the capacity, so that there are
+// misses even with the uniform distribution.
+func newBenchWorkload(capacity int, dist string, valueSize int, readPct int) *benchWorkload {
+	r := rand.New(rand.NewSource(1))
+	n := 4 * capacity
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+	}
+
+	next := func() int { return r.Intn(n) }
+	if dist == "zipf" {
+		z := rand.NewZipf(r, 1.1, 1, uint64(n-1))
+		next = func() int { return int(z.Uint64()) }
+	}
+
+	w := &benchWorkload{
+		keys:  make([]string, benchOps),
+		reads: make([]bool, benchOps),
+		value: make([]byte, valueSize), // boxed once, Set doesn't allocate
+	}
+	for i := 0; i < benchOps; i++ {
+		w.keys[i] = names[next()]
+		w.reads[i] = r.Intn(100) < readPct
+	}
+	return w
+}
+
+// Run one access. Misses are followed by a Set, like in a read-through
+// cache.
+func (w *benchWorkload) op(c benchCache, i int) {
+	i &= benchOps - 1
+	key := w.keys[i]
+	if w.reads[i] {
+		if _, ok := c.Get(key); ok {
+			return
+		}
+	}
+	c.Set(key, w.value)
+}
+
+func BenchmarkCache(b *testing.B) {
+	for _, capacity := range []int{1000, 100000} {
+		for _, dist := range []string{"uniform", "zipf"} {
+			for _, valueSize := range []int{16, 1024} {
+				for _, readPct := range []int{50, 90} {
+					w := newBenchWorkload(capacity, dist, valueSize, readPct)
+					for _, bc := range benchCaches {
+						name := fmt.Sprintf("%s/cap=%d/%s/value=%d/read=%d", bc.name, capacity, dist, valueSize, readPct)
+						b.Run(name+"/serial", func(b *testing.B) {
+							benchSerial(b, bc.make(capacity), w)
+						})
+						b.Run(name+"/parallel", func(b *testing.B) {
+							benchParallel(b, bc.make(capacity), w)
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+// Fill the cache with the accesses first, so that it's measured in
+// a steady state.
+func warmUp(c benchCache, w *benchWorkload) {
+	for i := 0; i < benchOps; i++ {
+		w.op(c, i)
+	}
+}
+
+func benchSerial(b *testing.B, c benchCache, w *benchWorkload) {
+	warmUp(c, w)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.op(c, i)
+	}
+}
+
+// GOMAXPROCS goroutines, use -cpu to change.
+func benchParallel(b *testing.B, c benchCache, w *benchWorkload) {
+	warmUp(c, w)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Start every goroutine somewhere else in the accesses.
+		i := rand.Intn(benchOps)
+		for pb.Next() {
+			w.op(c, i)
+			i += 1
+		}
+	})
+}
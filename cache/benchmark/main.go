@@ -1,94 +1,83 @@
+// Hit ratio simulator. Replays synthetic workloads or access logs
+// against the caches, printing hit ratio for every capacity.
+// Throughput is measured by the benchmarks in benchmark_test.go.
+
 package main
 
 import (
-	"fmt"
-	"time"
-	"math/rand"
-	"math"
+	"encoding/csv"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
 )
 
-func randomString(l int) string {
-	bytes := make([]byte, l)
-	for i := 0; i < l; i++ {
-		bytes[i] = byte(65 + rand.Intn(90-65))
-	}
-	return string(bytes)
-}
-
-type GenericCache interface {
-	Set(key string, value string)
-	Get(key string) (string, bool)
-}
-
 func main() {
-	list_of_capacities := []uint64{32, 128, 1024, 4096, 1024*1024}
-	number_of_keys := 30000
-	key_length := 3
-
-	keys := make([]string, number_of_keys)
-	for i := 0; i < 1000; i++ {
-		keys[i] = randomString(key_length)
-	}
-
-	for _, capacity := range(list_of_capacities) {
-		m := make([]GenericCache, 3)
-
-		fmt.Printf("[*] Capacity=%v Keys=%v KeySpace=%v\n", capacity, number_of_keys, int(math.Pow(90-65.,  float64(key_length))))
-		fmt.Printf("\t\tvitess\t\tLRUCache\tMultiLRUCache-4\n")
-
-		tc0 := time.Now()
-		m[0] = (GenericCache)(NewVCache(capacity))
-		tc1 := time.Now()
-		m[1] = (GenericCache)(NewMCache(capacity, makeLRUCache))
-		tc2 := time.Now()
-		m[2] = (GenericCache)(NewMCache(capacity, makeMultiLRU))
-		tc3 := time.Now()
+	workloads := flag.String("workload", "zipf,scan,loop", "synthetic workloads: zipf, scan, loop")
+	trace := flag.String("trace", "", "replay an access log instead, one key per line")
+	field := flag.Int("field", 0, "field of the trace line holding the key, from zero")
+	policies := flag.String("policies", strings.Join(policyNames(), ","), "caches to compare")
+	capacities := flag.String("capacities", "100,1000,10000", "cache capacities")
+	requests := flag.Int("requests", 1000000, "number of synthetic requests")
+	keys := flag.Int("keys", 100000, "number of distinct synthetic keys")
+	zipf := flag.Float64("zipf", 1.1, "zipf exponent, above 1")
+	seed := flag.Int64("seed", 1, "random seed for synthetic workloads")
+	asCSV := flag.Bool("csv", false, "print results as CSV")
+	flag.Parse()
 
-		fmt.Printf("create\t\t%-10v\t%-10v\t%v\n", tc1.Sub(tc0), tc2.Sub(tc1), tc3.Sub(tc2))
-
-		fmt.Printf("Get (miss)")
-		for _, c := range m {
-			t0 := time.Now()
-			for i := 0; i < 1000000; i++ {
-				c.Get(keys[i % len(keys)])
-			}
-			td := time.Since(t0)
-			fmt.Printf("\t%v", td)
+	var ws []Workload
+	if *trace != "" {
+		w, err := TraceWorkload(*trace, *field)
+		if err != nil {
+			log.Fatal(err)
 		}
-		fmt.Printf("\n")
-
-		fmt.Printf("Set #1\t")
-		for _, c := range m {
-			t0 := time.Now()
-			for i := 0; i < 1000000; i++ {
-				c.Set(keys[i % len(keys)], "v")
+		ws = append(ws, w)
+	} else {
+		for _, name := range strings.Split(*workloads, ",") {
+			switch name {
+			case "zipf":
+				ws = append(ws, ZipfWorkload(*requests, *keys, *zipf, *seed))
+			case "scan":
+				ws = append(ws, ScanWorkload(*requests, *keys, *zipf, 1000, 1000, *seed))
+			case "loop":
+				ws = append(ws, LoopWorkload(*requests, *keys))
+			default:
+				log.Fatalf("unknown workload %q", name)
 			}
-			td := time.Since(t0)
-			fmt.Printf("\t%v", td)
 		}
-		fmt.Printf("\n")
-
+	}
 
-		fmt.Printf("Get (hit)")
-		for _, c := range m {
-			t0 := time.Now()
-			for i := 0; i < 1000000; i++ {
-				c.Get(keys[i % len(keys)])
-			}
-			td := time.Since(t0)
-			fmt.Printf("\t%v", td)
+	ps := strings.Split(*policies, ",")
+	for _, p := range ps {
+		if simPolicies[p] == nil {
+			log.Fatalf("unknown policy %q, expecting one of %v", p, policyNames())
 		}
-		fmt.Printf("\n")
+	}
+	var cs []uint64
+	for _, c := range strings.Split(*capacities, ",") {
+		n, err := strconv.ParseUint(c, 10, 64)
+		if err != nil || n == 0 {
+			log.Fatalf("bad capacity %q", c)
+		}
+		cs = append(cs, n)
+	}
 
-		fmt.Printf("Set #2\t")
-		for _, c := range m {
-		t0 := time.Now()
-			for i := 0; i < 1000000; i++ {
-				c.Set(keys[i % len(keys)], "v")
+	out := csv.NewWriter(os.Stdout)
+	if *asCSV {
+		csvHeader(out)
+	}
+	for _, w := range ws {
+		var results []simResult
+		for _, c := range cs {
+			for _, p := range ps {
+				results = append(results, simulate(w, p, c))
 			}
-			td := time.Since(t0)
-			fmt.Printf("\t%v", td)
 		}
-		fmt.Printf("\n\n")
+		if *asCSV {
+			printCSV(out, results)
+		} else {
+			printTable(os.Stdout, w, ps, results)
+		}
 	}
 }
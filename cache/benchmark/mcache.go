@@ -4,42 +4,22 @@ import (
 	mcache "github.com/majek/goplayground/cache"
 	"github.com/majek/goplayground/cache/lrucache"
 	"github.com/majek/goplayground/cache/multilru"
-	"time"
 )
 
-func makeLRUCache(capacity uint64) mcache.Cache {
-	return lrucache.NewLRUCache(uint(capacity))
-}
-func makeMultiLRU(capacity uint64) mcache.Cache {
-	shards := uint(2)
-	return multilru.NewMultiLRUCache(shards, uint(capacity)/shards)
-}
-
-type MCache struct {
-	mcache.Cache
-	expiry time.Time
-	now time.Time
-}
-
 type makeCache func(capacity uint64) mcache.Cache
 
-func NewMCache(capacity uint64, newCache makeCache) *MCache {
-	return &MCache{
-		Cache: newCache(capacity),
-		expiry: time.Now().Add(time.Duration(30*time.Second)),
-		now: time.Now(),
-	}
+func makeLRUCache(capacity uint64) mcache.Cache {
+	return lrucache.NewLRUCache(uint(capacity))
 }
 
-func (c *MCache) Get(key string) (string, bool) {
-	v, ok := c.Cache.Get(key)
-	if !ok {
-		return "", false
+// Eight buckets holding exactly `capacity` entries together, so that
+// hit ratios compare with the other caches. CRC32Hash puts the keys
+// in the same buckets on every run.
+func makeMultiLRU(global bool) makeCache {
+	return func(capacity uint64) mcache.Cache {
+		o := multilru.Options{Hash: multilru.CRC32Hash, Global: global}
+		m := multilru.NewMultiLRUCacheOptions(8, uint(capacity+7)/8, o)
+		m.Resize(uint(capacity))
+		return m
 	}
-	return v.(*Value).v, true
 }
-
-func (c *MCache) Set(key, value string) {
-	c.Cache.Set(key, &Value{v: value}, time.Time{})
-}
-
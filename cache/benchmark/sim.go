@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	mcache "github.com/majek/goplayground/cache"
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+// Cache implementations compared by the simulator, by name.
+var simPolicies = map[string]makeCache{
+	"lru":             makeLRUCache,
+	"slru":            makePolicy(lrucache.PolicySLRU),
+	"tinylfu":         makePolicy(lrucache.PolicyTinyLFU),
+	"multilru":        makeMultiLRU(false),
+	"multilru-global": makeMultiLRU(true),
+}
+
+func makePolicy(p lrucache.Policy) makeCache {
+	return func(capacity uint64) mcache.Cache {
+		return lrucache.NewLRUCacheOptions(uint(capacity), lrucache.Options{Policy: p})
+	}
+}
+
+func policyNames() []string {
+	var names []string
+	for name := range simPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type simResult struct {
+	workload string
+	policy   string
+	capacity uint64
+	requests int
+	hits     int
+}
+
+func (r simResult) hitRatio() float64 {
+	if r.requests == 0 {
+		return 0
+	}
+	return float64(r.hits) / float64(r.requests)
+}
+
+// Replay the workload against a fresh cache. Every miss is followed
+// by a Set, like a read-through cache would do.
+func simulate(w Workload, policy string, capacity uint64) simResult {
+	c := simPolicies[policy](capacity)
+	r := simResult{workload: w.Name, policy: policy, capacity: capacity}
+	for key := range w.Keys() {
+		r.requests += 1
+		if _, ok := c.Get(key); ok {
+			r.hits += 1
+		} else {
+			c.Set(key, true, time.Time{})
+		}
+	}
+	return r
+}
+
+// Print results as a table, one row per capacity and one column per
+// policy.
+func printTable(out io.Writer, w Workload, policies []string, results []simResult) {
+	fmt.Fprintf(out, "[*] Workload=%v Requests=%v\n", w.Name, results[0].requests)
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "capacity")
+	for _, p := range policies {
+		fmt.Fprintf(tw, "\t%s", p)
+	}
+	fmt.Fprintf(tw, "\n")
+	for i := 0; i < len(results); i += len(policies) {
+		fmt.Fprintf(tw, "%d", results[i].capacity)
+		for _, r := range results[i : i+len(policies)] {
+			fmt.Fprintf(tw, "\t%.4f", r.hitRatio())
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "\n")
+}
+
+func csvHeader(out *csv.Writer) {
+	out.Write([]string{"workload", "policy", "capacity", "requests", "hits", "hit_ratio"})
+}
+
+func printCSV(out *csv.Writer, results []simResult) {
+	for _, r := range results {
+		out.Write([]string{
+			r.workload,
+			r.policy,
+			strconv.FormatUint(r.capacity, 10),
+			strconv.Itoa(r.requests),
+			strconv.Itoa(r.hits),
+			strconv.FormatFloat(r.hitRatio(), 'f', 6, 64),
+		})
+	}
+	out.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkloads(t *testing.T) {
+	t.Parallel()
+
+	for _, w := range []Workload{
+		ZipfWorkload(1000, 100, 1.1, 1),
+		ScanWorkload(1000, 100, 1.1, 100, 10, 1),
+		LoopWorkload(1000, 100),
+	} {
+		var first, second []string
+		for key := range w.Keys() {
+			first = append(first, key)
+		}
+		for key := range w.Keys() {
+			second = append(second, key)
+		}
+		if len(first) < 1000 || fmt.Sprint(first) != fmt.Sprint(second) {
+			t.Errorf("%s: expecting the same keys on replay", w.Name)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "trace")
+	os.WriteFile(path, []byte("# comment\n1 a\n2 b\n\n3 a\n4\n"), 0600)
+	w, err := TraceWorkload(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range w.Keys() {
+		keys = append(keys, key)
+	}
+	if fmt.Sprint(keys) != "[a b a]" {
+		t.Errorf("expecting different keys %v", keys)
+	}
+
+	r := simulate(w, "lru", 10)
+	if r.requests != 3 || r.hits != 1 {
+		t.Errorf("expecting one hit %+v", r)
+	}
+	if _, err := TraceWorkload(path+"missing", 0); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestSimCapacity(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range policyNames() {
+		if c := simPolicies[name](100); c.Capacity() != 100 {
+			t.Errorf("%s: expecting exact capacity, got %d", name, c.Capacity())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"iter"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sequence of accessed keys. Workloads can be replayed many times,
+// always producing the same keys.
+type Workload struct {
+	Name string
+	Keys func() iter.Seq[string]
+}
+
+// Keys drawn from a Zipf distribution over `keys` keys, with
+// exponent s > 1. Popular keys are hit much more often.
+func ZipfWorkload(requests, keys int, s float64, seed int64) Workload {
+	return Workload{
+		Name: fmt.Sprintf("zipf-%g", s),
+		Keys: func() iter.Seq[string] {
+			return func(yield func(string) bool) {
+				r := rand.New(rand.NewSource(seed))
+				z := rand.NewZipf(r, s, 1, uint64(keys-1))
+				for i := 0; i < requests; i++ {
+					if !yield(strconv.FormatUint(z.Uint64(), 10)) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// Zipf accesses interrupted every `every` requests by a scan of
+// `scan` keys never seen before. Plain LRU loses its hot keys on
+// every scan.
+func ScanWorkload(requests, keys int, s float64, every, scan int, seed int64) Workload {
+	zipf := ZipfWorkload(requests, keys, s, seed)
+	return Workload{
+		Name: fmt.Sprintf("scan-%d", scan),
+		Keys: func() iter.Seq[string] {
+			return func(yield func(string) bool) {
+				i, scanned := 0, 0
+				for key := range zipf.Keys() {
+					if i > 0 && i%every == 0 {
+						for j := 0; j < scan; j++ {
+							scanned += 1
+							if !yield("scan" + strconv.Itoa(scanned)) {
+								return
+							}
+						}
+					}
+					i += 1
+					if !yield(key) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// The same `keys` keys accessed in a loop. Worst case for LRU, which
+// never hits once the loop doesn't fit.
+func LoopWorkload(requests, keys int) Workload {
+	return Workload{
+		Name: fmt.Sprintf("loop-%d", keys),
+		Keys: func() iter.Seq[string] {
+			return func(yield func(string) bool) {
+				for i := 0; i < requests; i++ {
+					if !yield(strconv.Itoa(i % keys)) {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// Replay an access log, one request per line. The key is the given
+// whitespace separated field, counting from zero. Blank lines and
+// lines starting with # are skipped. The file is read again on every
+// replay, read errors are fatal.
+func TraceWorkload(path string, field int) (Workload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Workload{}, err
+	}
+	f.Close()
+
+	return Workload{
+		Name: path,
+		Keys: func() iter.Seq[string] {
+			return func(yield func(string) bool) {
+				f, err := os.Open(path)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+
+				s := bufio.NewScanner(f)
+				s.Buffer(nil, 1<<20)
+				for s.Scan() {
+					line := s.Text()
+					if len(line) == 0 || line[0] == '#' {
+						continue
+					}
+					fields := strings.Fields(line)
+					if field >= len(fields) {
+						continue
+					}
+					if !yield(fields[field]) {
+						return
+					}
+				}
+				if err := s.Err(); err != nil {
+					log.Fatalf("%s: %v", path, err)
+				}
+			}
+		},
+	}, nil
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"time"
 )
 
@@ -18,8 +19,31 @@ type Cache interface {
 	GetNotStale(key string) (value interface{}, ok bool)
 	Expire() int
 
-	// manually specify time used when neccessary to expire entries
+	// manually specify time used when neccessary to expire entries,
+	// kept for compatibility: implementations take a clock in their
+	// options, set it to control the time of the methods above
 	SetNow(key string, value interface{}, expire time.Time, now time.Time)
 	GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool)
 	ExpireNow(now time.Time) int
 }
+
+// Cache able to fill itself on a miss. Concurrent misses on the same
+// key are expected to result in a single loader call. The Ctx
+// variants stop waiting when the context is done, while the shared
+// load goes on.
+type LoadingCache interface {
+	Cache
+
+	GetOrLoad(key string, loader func() (value interface{}, expire time.Time, err error)) (value interface{}, err error)
+	GetOrLoadCtx(ctx context.Context, key string, loader func(ctx context.Context) (value interface{}, expire time.Time, err error)) (value interface{}, err error)
+	GetCtx(ctx context.Context, key string) (value interface{}, ok bool, err error)
+}
+
+// Cache with atomic read-modify-write operations.
+type AtomicCache interface {
+	Cache
+
+	SetIfAbsent(key string, value interface{}, expire time.Time) bool
+	CompareAndSwap(key string, old, new interface{}) bool
+	Update(key string, fn func(old interface{}, ok bool) (value interface{}, expire time.Time, keep bool)) (value interface{}, ok bool)
+}
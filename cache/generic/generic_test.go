@@ -0,0 +1,131 @@
+package generic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBasicExpiry(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache[string, string](3)
+	if _, ok := b.Get("a"); ok {
+		t.Error("")
+	}
+
+	now := time.Now()
+	b.Set("b", "vb", now.Add(time.Duration(2*time.Second)))
+	b.Set("a", "va", now.Add(time.Duration(1*time.Second)))
+	b.Set("c", "vc", now.Add(time.Duration(3*time.Second)))
+
+	if v, _ := b.Get("a"); v != "va" {
+		t.Error("")
+	}
+	if v, _ := b.Get("b"); v != "vb" {
+		t.Error("")
+	}
+	if v, _ := b.Get("c"); v != "vc" {
+		t.Error("")
+	}
+
+	b.Set("d", "vd", now.Add(time.Duration(4*time.Second)))
+	if _, ok := b.Get("a"); ok {
+		t.Error("Expecting element A to be evicted")
+	}
+
+	b.Set("e", "ve", now.Add(time.Duration(-4*time.Second)))
+	if _, ok := b.Get("b"); ok {
+		t.Error("Expecting element B to be evicted")
+	}
+	if _, ok := b.GetNotStale("e"); ok {
+		t.Error("Expecting element E to be stale")
+	}
+	if b.Len() != 2 {
+		t.Error("Expecting different length")
+	}
+
+	if v, ok := b.Del("c"); v != "vc" || !ok {
+		t.Error("Expecting hit")
+	}
+	if v, ok := b.GetQuiet("c"); v != "" || ok {
+		t.Error("Expecting miss")
+	}
+
+	b.Set("f", "vf", now.Add(time.Duration(-4*time.Second)))
+	if b.Expire() != 1 || b.Clear() != 1 || b.Len() != 0 {
+		t.Error("Expecting different length")
+	}
+	if b.Capacity() != 3 {
+		t.Error("Expecting different capacity")
+	}
+}
+
+type point struct {
+	x, y int
+}
+
+func TestStructKeys(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache[point, int](2)
+
+	b.Set(point{1, 2}, 3, time.Time{})
+	b.Set(point{2, 3}, 5, time.Time{})
+	b.Get(point{1, 2})
+	b.Set(point{3, 4}, 7, time.Time{})
+
+	if v, _ := b.Get(point{1, 2}); v != 3 {
+		t.Error("Expecting hit")
+	}
+	if _, ok := b.Get(point{2, 3}); ok {
+		t.Error("Expecting miss")
+	}
+}
+
+func TestNoAllocs(t *testing.T) {
+	b := NewLRUCache[int, int](100)
+	expire := time.Now().Add(time.Hour)
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		b.Set(i%200, i, expire)
+		b.Get(i % 200)
+		i += 1
+	})
+	// Map growth aside, entries are reused
+	if allocs > 0.1 {
+		t.Errorf("Expecting no allocations, got %v", allocs)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache[int, string](4, 2)
+
+	for i := 0; i < 100; i++ {
+		m.Set(i, "v", time.Time{})
+	}
+	if m.Len() != 8 || m.Capacity() != 8 {
+		t.Error("Expecting different length")
+	}
+
+	m.Set(1000, "x", time.Time{})
+	if v, _ := m.Get(1000); v != "x" {
+		t.Error("Expecting hit")
+	}
+	if v, _ := m.Del(1000); v != "x" {
+		t.Error("Expecting hit")
+	}
+	if m.Clear() != 7 {
+		t.Error("Expecting different length")
+	}
+}
+
+func BenchmarkGet(bb *testing.B) {
+	b := NewLRUCache[int, int](1000)
+	for i := 0; i < 1000; i++ {
+		b.Set(i, i, time.Time{})
+	}
+	bb.ReportAllocs()
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.Get(i % 2000)
+	}
+}
@@ -0,0 +1,59 @@
+package generic
+
+// Minimal intrusive doubly linked list of entries, modelled after
+// container/list. Implemented as a ring with `root` as sentinel.
+type list[K comparable, V any] struct {
+	root entry[K, V]
+	len  int
+}
+
+func (l *list[K, V]) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.len = 0
+}
+
+func (l *list[K, V]) front() *entry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+func (l *list[K, V]) back() *entry[K, V] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+func (l *list[K, V]) pushFront(e *entry[K, V]) {
+	at := &l.root
+	n := at.next
+	at.next = e
+	e.prev = at
+	e.next = n
+	n.prev = e
+	e.list = l
+	l.len++
+}
+
+func (l *list[K, V]) pushBack(e *entry[K, V]) {
+	at := l.root.prev
+	n := at.next
+	at.next = e
+	e.prev = at
+	e.next = n
+	n.prev = e
+	e.list = l
+	l.len++
+}
+
+func (l *list[K, V]) remove(e *entry[K, V]) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.next = nil
+	e.prev = nil
+	e.list = nil
+	l.len--
+}
@@ -0,0 +1,298 @@
+// Type safe LRU cache data structure
+//
+// Same design as cache/lrucache, but keys and values have static
+// types:
+//
+//  - Avoids dynamic memory allocations. All memory is allocated
+//    on creation, values are stored in place without boxing.
+//  - Any comparable type can be used as a key.
+//  - Access is O(1). Modification O(log(n)) if expiry is set, O(1) if expiry is zero.
+//  - Multithreading supported using a mutex lock.
+//
+// Every element in the cache is linked to three data structures:
+// `table` map, `priorityQueue` ordered by expiry and `lruList`
+// ordered by decreasing popularity.
+
+package generic
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	next, prev *entry[K, V] // list pointers
+	list       *list[K, V]  // list this entry is linked to
+	key        K            // key is a key!
+	value      V            //
+	expire     time.Time    // time when the item is expired. it's okay to be stale.
+	index      int          // index for priority queue needs. -1 if entry is free
+}
+
+// Is the entry past its expiry time? Entries without expiry never are.
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expire.IsZero() && e.expire.Before(now)
+}
+
+type LRUCache[K comparable, V any] struct {
+	lock          sync.Mutex
+	table         map[K]*entry[K, V]  // all entries in table must be in lruList
+	priorityQueue priorityQueue[K, V] // some elements from table may be in priorityQueue
+	lruList       list[K, V]          // every entry is either used and resides in lruList
+	freeList      list[K, V]          // or free and is linked to freeList
+}
+
+// Initialize the LRU cache instance. O(capacity)
+func (b *LRUCache[K, V]) Init(capacity uint) {
+	b.table = make(map[K]*entry[K, V], capacity)
+	b.priorityQueue = make(priorityQueue[K, V], 0, capacity)
+	b.lruList.init()
+	b.freeList.init()
+	heap.Init(&b.priorityQueue)
+
+	// Reserve all the entries in one giant continous block of memory
+	arrayOfEntries := make([]entry[K, V], capacity)
+	for i := uint(0); i < capacity; i++ {
+		e := &arrayOfEntries[i]
+		e.index = -1
+		b.freeList.pushBack(e)
+	}
+}
+
+// Create new LRU cache instance. Allocate all the needed memory. O(capacity)
+func NewLRUCache[K comparable, V any](capacity uint) *LRUCache[K, V] {
+	b := &LRUCache[K, V]{}
+	b.Init(capacity)
+	return b
+}
+
+// Give me the entry with lowest expiry field if it's before now.
+func (b *LRUCache[K, V]) expiredEntry(now time.Time) *entry[K, V] {
+	if len(b.priorityQueue) == 0 {
+		return nil
+	}
+
+	if now.IsZero() {
+		// Fill it only when actually used.
+		now = time.Now()
+	}
+	e := b.priorityQueue[0]
+	if e.expire.Before(now) {
+		return e
+	}
+	return nil
+}
+
+func (b *LRUCache[K, V]) freeSomeEntry(now time.Time) (e *entry[K, V], used bool) {
+	if b.freeList.len > 0 {
+		return b.freeList.front(), false
+	}
+
+	e = b.expiredEntry(now)
+	if e != nil {
+		return e, true
+	}
+
+	if b.lruList.len == 0 {
+		return nil, false
+	}
+
+	return b.lruList.back(), true
+}
+
+// Move entry from used/lru list to a free list. Clear the entry as well.
+func (b *LRUCache[K, V]) removeEntry(e *entry[K, V]) {
+	if e.list != &b.lruList {
+		panic("list lruList")
+	}
+
+	if e.index != -1 {
+		heap.Remove(&b.priorityQueue, e.index)
+	}
+	b.lruList.remove(e)
+	b.freeList.pushFront(e)
+	delete(b.table, e.key)
+	var zero entry[K, V]
+	e.key = zero.key
+	e.value = zero.value
+}
+
+func (b *LRUCache[K, V]) insertEntry(e *entry[K, V]) {
+	if e.list != &b.freeList {
+		panic("list freeList")
+	}
+
+	if !e.expire.IsZero() {
+		heap.Push(&b.priorityQueue, e)
+	}
+	b.freeList.remove(e)
+	b.lruList.pushFront(e)
+	b.table[e.key] = e
+}
+
+func (b *LRUCache[K, V]) touchEntry(e *entry[K, V]) {
+	b.lruList.remove(e)
+	b.lruList.pushFront(e)
+}
+
+// Add an item to the cache overwriting existing one if it
+// exists. Allows specifing current time required to expire an
+// item when no more slots are used. O(log(n)) if expiry is set,
+// O(1) when clear.
+func (b *LRUCache[K, V]) SetNow(key K, value V, expire time.Time, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var used bool
+
+	e := b.table[key]
+	if e != nil {
+		used = true
+	} else {
+		e, used = b.freeSomeEntry(now)
+		if e == nil {
+			return
+		}
+	}
+	if used {
+		b.removeEntry(e)
+	}
+
+	e.key = key
+	e.value = value
+	e.expire = expire
+	b.insertEntry(e)
+}
+
+// Add an item to the cache overwriting existing one if it
+// exists. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache[K, V]) Set(key K, value V, expire time.Time) {
+	b.SetNow(key, value, expire, time.Time{})
+}
+
+// Get a key from the cache, possibly stale. Update its LRU score. O(1)
+func (b *LRUCache[K, V]) Get(key K) (v V, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.table[key]
+	if e == nil {
+		return v, false
+	}
+
+	b.touchEntry(e)
+	return e.value, true
+}
+
+// Get a key from the cache, possibly stale. Don't modify its LRU score. O(1)
+func (b *LRUCache[K, V]) GetQuiet(key K) (v V, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.table[key]
+	if e == nil {
+		return v, false
+	}
+
+	return e.value, true
+}
+
+// Get a key from the cache, make sure it's not stale. Update its
+// LRU score. O(log(n)) if the item is expired.
+func (b *LRUCache[K, V]) GetNotStale(key K) (v V, ok bool) {
+	return b.GetNotStaleNow(key, time.Now())
+}
+
+// Get a key from the cache, make sure it's not stale. Update its
+// LRU score. O(log(n)) if the item is expired.
+func (b *LRUCache[K, V]) GetNotStaleNow(key K, now time.Time) (v V, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.table[key]
+	if e == nil {
+		return v, false
+	}
+
+	if e.expired(now) {
+		b.removeEntry(e)
+		return v, false
+	}
+
+	b.touchEntry(e)
+	return e.value, true
+}
+
+// Get and remove a key from the cache. O(log(n)) if the item is using expiry, O(1) otherwise.
+func (b *LRUCache[K, V]) Del(key K) (v V, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.table[key]
+	if e == nil {
+		return v, false
+	}
+
+	v = e.value
+	b.removeEntry(e)
+	return v, true
+}
+
+// Evict all items from the cache. O(n*log(n))
+func (b *LRUCache[K, V]) Clear() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// First, remove entries that have expiry set
+	l := len(b.priorityQueue)
+	for i := 0; i < l; i++ {
+		// This could be reduced to O(n).
+		b.removeEntry(b.priorityQueue[0])
+	}
+
+	// Second, remove all remaining entries
+	r := b.lruList.len
+	for i := 0; i < r; i++ {
+		b.removeEntry(b.lruList.back())
+	}
+	return l + r
+}
+
+// Evict all the expired items. O(n*log(n))
+func (b *LRUCache[K, V]) Expire() int {
+	return b.ExpireNow(time.Now())
+}
+
+// Evict items that expire before `now`. O(n*log(n))
+func (b *LRUCache[K, V]) ExpireNow(now time.Time) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	i := 0
+	for {
+		e := b.expiredEntry(now)
+		if e == nil {
+			break
+		}
+		b.removeEntry(e)
+		i += 1
+	}
+	return i
+}
+
+// Number of entries used in the LRU
+func (b *LRUCache[K, V]) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.lruList.len
+}
+
+// Get the total capacity of the LRU
+func (b *LRUCache[K, V]) Capacity() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.lruList.len + b.freeList.len
+}
@@ -0,0 +1,101 @@
+package generic
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+type MultiLRUCache[K comparable, V any] struct {
+	buckets uint
+	cache   []*LRUCache[K, V]
+	seed    maphash.Seed
+}
+
+// Using this constructor is almost always wrong. Use NewMultiLRUCache instead.
+func (m *MultiLRUCache[K, V]) Init(buckets, bucket_capacity uint) {
+	m.buckets = buckets
+	m.cache = make([]*LRUCache[K, V], buckets)
+	m.seed = maphash.MakeSeed()
+	for i := uint(0); i < buckets; i++ {
+		m.cache[i] = NewLRUCache[K, V](bucket_capacity)
+	}
+}
+
+func NewMultiLRUCache[K comparable, V any](buckets, bucket_capacity uint) *MultiLRUCache[K, V] {
+	m := &MultiLRUCache[K, V]{}
+	m.Init(buckets, bucket_capacity)
+	return m
+}
+
+func (m *MultiLRUCache[K, V]) bucketNo(key K) uint {
+	// Seeded per instance, works for any comparable key.
+	return uint(maphash.Comparable(m.seed, key) % uint64(m.buckets))
+}
+
+func (m *MultiLRUCache[K, V]) Set(key K, value V, expire time.Time) {
+	m.cache[m.bucketNo(key)].Set(key, value, expire)
+}
+
+func (m *MultiLRUCache[K, V]) SetNow(key K, value V, expire time.Time, now time.Time) {
+	m.cache[m.bucketNo(key)].SetNow(key, value, expire, now)
+}
+
+func (m *MultiLRUCache[K, V]) Get(key K) (value V, ok bool) {
+	return m.cache[m.bucketNo(key)].Get(key)
+}
+
+func (m *MultiLRUCache[K, V]) GetQuiet(key K) (value V, ok bool) {
+	return m.cache[m.bucketNo(key)].GetQuiet(key)
+}
+
+func (m *MultiLRUCache[K, V]) GetNotStale(key K) (value V, ok bool) {
+	return m.cache[m.bucketNo(key)].GetNotStale(key)
+}
+
+func (m *MultiLRUCache[K, V]) GetNotStaleNow(key K, now time.Time) (value V, ok bool) {
+	return m.cache[m.bucketNo(key)].GetNotStaleNow(key, now)
+}
+
+func (m *MultiLRUCache[K, V]) Del(key K) (value V, ok bool) {
+	return m.cache[m.bucketNo(key)].Del(key)
+}
+
+func (m *MultiLRUCache[K, V]) Clear() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Clear()
+	}
+	return s
+}
+
+func (m *MultiLRUCache[K, V]) Len() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Len()
+	}
+	return s
+}
+
+func (m *MultiLRUCache[K, V]) Capacity() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Capacity()
+	}
+	return s
+}
+
+func (m *MultiLRUCache[K, V]) Expire() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Expire()
+	}
+	return s
+}
+
+func (m *MultiLRUCache[K, V]) ExpireNow(now time.Time) int {
+	var s int
+	for _, c := range m.cache {
+		s += c.ExpireNow(now)
+	}
+	return s
+}
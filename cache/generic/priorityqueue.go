@@ -0,0 +1,33 @@
+package generic
+
+type priorityQueue[K comparable, V any] []*entry[K, V]
+
+func (pq priorityQueue[K, V]) Len() int {
+	return len(pq)
+}
+
+func (pq priorityQueue[K, V]) Less(i, j int) bool {
+	return pq[i].expire.Before(pq[j].expire)
+}
+
+func (pq priorityQueue[K, V]) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *priorityQueue[K, V]) Push(e any) {
+	n := len(*pq)
+	item := e.(*entry[K, V])
+	item.index = n
+	*pq = append(*pq, item)
+}
+
+func (pq *priorityQueue[K, V]) Pop() any {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	item.index = -1
+	*pq = old[0 : n-1]
+	return item
+}
@@ -0,0 +1,285 @@
+package invalidate
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/majek/goplayground/cache"
+)
+
+// Settings for the Client.
+type Options struct {
+	// Identifies the client, its own messages are ignored.
+	// Defaults to a random string.
+	Origin string
+
+	// Called for keys invalidated by other clients.
+	OnInvalidate func(key string)
+
+	// Called when invalidations might have been missed, or another
+	// client asked for it.
+	OnClear func()
+
+	// Messages kept while disconnected. When there are more, the
+	// other clients are asked to clear instead. Defaults to 1024.
+	MaxPending int
+
+	// Pause between connection attempts. Defaults to a second.
+	RetryInterval time.Duration
+
+	// How long sending a message to the hub may block. After that
+	// the connection is dropped and messages are kept until the
+	// next one. Defaults to a second.
+	WriteTimeout time.Duration
+}
+
+// Connection to a Hub, reconnecting as needed.
+type Client struct {
+	addr     string
+	o        Options
+	lock     sync.Mutex
+	conn     net.Conn // nil if not connected
+	pending  []string // messages to send after reconnect
+	overflow bool     // pending messages were dropped
+	epoch    uint64   // of the hub, zero before connecting
+	seq      uint64   // last message seen
+	closed   chan struct{}
+	done     chan struct{}
+}
+
+// Connect to the hub at addr in the background.
+func Subscribe(addr string, o Options) *Client {
+	if o.Origin == "" {
+		o.Origin = strconv.FormatUint(rand.Uint64(), 36)
+	}
+	if o.MaxPending == 0 {
+		o.MaxPending = 1024
+	}
+	if o.RetryInterval == 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = time.Second
+	}
+	c := &Client{
+		addr:   addr,
+		o:      o,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Tell the other clients to drop the key.
+func (c *Client) Invalidate(key string) {
+	c.send(fmt.Sprintf("INV %s %s\n", c.o.Origin, strconv.Quote(key)))
+}
+
+// Tell the other clients to drop everything.
+func (c *Client) InvalidateAll() {
+	c.send(fmt.Sprintf("CLEAR %s\n", c.o.Origin))
+}
+
+func (c *Client) send(m string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != nil {
+		if err := c.write(c.conn, m); err == nil {
+			return
+		}
+		// The reader will notice and reconnect.
+		c.conn.Close()
+		c.conn = nil
+	}
+	if len(c.pending) >= c.o.MaxPending {
+		c.overflow = true
+		c.pending = nil
+	}
+	if !c.overflow {
+		c.pending = append(c.pending, m)
+	}
+}
+
+// Send with a deadline, a stalled hub must not block Invalidate
+// for long, it's called with the lock held.
+func (c *Client) write(conn net.Conn, m string) error {
+	conn.SetWriteDeadline(time.Now().Add(c.o.WriteTimeout))
+	_, err := conn.Write([]byte(m))
+	return err
+}
+
+// Is the client connected to the hub?
+func (c *Client) Connected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.conn != nil
+}
+
+// Disconnect and stop reconnecting.
+func (c *Client) Close() {
+	c.lock.Lock()
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.lock.Unlock()
+	<-c.done
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	for {
+		if conn, err := net.Dial("tcp", c.addr); err == nil {
+			c.session(conn)
+		}
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(c.o.RetryInterval):
+		}
+	}
+}
+
+func (c *Client) clear() {
+	if c.o.OnClear != nil {
+		c.o.OnClear()
+	}
+}
+
+func (c *Client) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	var epoch, seq uint64
+	line, err := r.ReadString('\n')
+	if err == nil {
+		_, err = fmt.Sscanf(line, "HELLO %d %d\n", &epoch, &seq)
+	}
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	select {
+	case <-c.closed:
+		c.lock.Unlock()
+		return
+	default:
+	}
+	missed := c.epoch != 0 && (c.epoch != epoch || c.seq != seq)
+	c.epoch, c.seq = epoch, seq
+	// Messages sent while disconnected go out first.
+	pending := strings.Join(c.pending, "")
+	if c.overflow {
+		pending = fmt.Sprintf("CLEAR %s\n", c.o.Origin)
+	}
+	if err := c.write(conn, pending); err != nil {
+		c.lock.Unlock()
+		return
+	}
+	c.pending, c.overflow = nil, false
+	c.conn = conn
+	c.lock.Unlock()
+
+	if missed {
+		c.clear()
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		c.receive(line)
+	}
+
+	c.lock.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.lock.Unlock()
+}
+
+// Handle a message from the hub.
+func (c *Client) receive(line string) {
+	f := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+	if len(f) < 3 {
+		return
+	}
+	seq, err := strconv.ParseUint(f[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	missed := seq != c.seq+1
+	c.seq = seq
+	c.lock.Unlock()
+
+	own := f[2] == c.o.Origin
+	switch {
+	case missed:
+		c.clear()
+	case f[0] == "CLEAR" && !own:
+		c.clear()
+	case f[0] == "INV" && len(f) == 4 && !own && c.o.OnInvalidate != nil:
+		if key, err := strconv.Unquote(f[3]); err == nil {
+			c.o.OnInvalidate(key)
+		}
+	}
+}
+
+// Cache publishing invalidations on Set, SetNow and Del, and dropping
+// keys changed by other clients. Other calls go straight to the
+// wrapped cache.
+type Cache struct {
+	cache.Cache
+	client *Client
+}
+
+// Wrap a cache and subscribe to the hub at addr. OnInvalidate and
+// OnClear are set to Del and Clear of the cache.
+func Wrap(c cache.Cache, addr string, o Options) *Cache {
+	o.OnInvalidate = func(key string) { c.Del(key) }
+	o.OnClear = func() { c.Clear() }
+	return &Cache{Cache: c, client: Subscribe(addr, o)}
+}
+
+func (w *Cache) Set(key string, value interface{}, expire time.Time) {
+	w.Cache.Set(key, value, expire)
+	w.client.Invalidate(key)
+}
+
+func (w *Cache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
+	w.Cache.SetNow(key, value, expire, now)
+	w.client.Invalidate(key)
+}
+
+func (w *Cache) Del(key string) (value interface{}, ok bool) {
+	value, ok = w.Cache.Del(key)
+	w.client.Invalidate(key)
+	return value, ok
+}
+
+// The underlying client.
+func (w *Cache) Client() *Client {
+	return w.client
+}
+
+// Disconnect from the hub.
+func (w *Cache) Close() {
+	w.client.Close()
+}
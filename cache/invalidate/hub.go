@@ -0,0 +1,167 @@
+// Invalidation bus for caches in separate processes.
+//
+// A Hub relays messages between connected clients. Every message
+// gets the next sequence number, and all the clients see messages in
+// the same order. Clients can tell they missed messages: either the
+// sequence number jumps, or after a reconnect the Hub reports
+// a different epoch (it was restarted) or a later sequence number.
+// Then the only safe thing is to drop the whole cache.
+//
+// The protocol is line based text:
+//
+//	hub -> client:  HELLO <epoch> <seq>
+//	client -> hub:  INV <origin> <quoted key>
+//	                CLEAR <origin>
+//	hub -> client:  INV <seq> <origin> <quoted key>
+//	                CLEAR <seq> <origin>
+
+package invalidate
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Messages waiting for a slow client. If it falls further behind it's
+// disconnected, and will clear its cache after reconnecting.
+const hubQueue = 4096
+
+type Hub struct {
+	lock      sync.Mutex
+	epoch     uint64
+	seq       uint64
+	clients   map[*hubClient]struct{}
+	listeners []net.Listener
+	closed    bool
+}
+
+type hubClient struct {
+	conn net.Conn
+	out  chan string
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		epoch:   rand.Uint64()>>1 + 1,
+		clients: make(map[*hubClient]struct{}),
+	}
+}
+
+// Accept clients until the listener or the hub is closed.
+func (h *Hub) Serve(l net.Listener) error {
+	h.lock.Lock()
+	if h.closed {
+		h.lock.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	h.listeners = append(h.listeners, l)
+	h.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go h.handle(conn)
+	}
+}
+
+// Stop the listeners and disconnect all the clients.
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+	for _, l := range h.listeners {
+		l.Close()
+	}
+	for c := range h.clients {
+		c.conn.Close()
+	}
+}
+
+func (h *Hub) handle(conn net.Conn) {
+	c := &hubClient{conn: conn, out: make(chan string, hubQueue)}
+
+	h.lock.Lock()
+	if h.closed {
+		h.lock.Unlock()
+		conn.Close()
+		return
+	}
+	// Sent before any message with a higher sequence number.
+	c.out <- fmt.Sprintf("HELLO %d %d\n", h.epoch, h.seq)
+	h.clients[c] = struct{}{}
+	h.lock.Unlock()
+
+	go h.write(c)
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		f := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+		switch {
+		case len(f) == 3 && f[0] == "INV":
+			h.publish(func(seq uint64) string {
+				return fmt.Sprintf("INV %d %s %s\n", seq, f[1], f[2])
+			})
+		case len(f) == 2 && f[0] == "CLEAR":
+			h.publish(func(seq uint64) string {
+				return fmt.Sprintf("CLEAR %d %s\n", seq, f[1])
+			})
+		}
+	}
+
+	h.drop(c)
+}
+
+// Send a message with the next sequence number to all the clients.
+func (h *Hub) publish(msg func(seq uint64) string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.seq += 1
+	m := msg(h.seq)
+	for c := range h.clients {
+		select {
+		case c.out <- m:
+		default:
+			// Too slow, it will have to start over.
+			h.dropLocked(c)
+		}
+	}
+}
+
+func (h *Hub) write(c *hubClient) {
+	w := bufio.NewWriter(c.conn)
+	for m := range c.out {
+		w.WriteString(m)
+		if len(c.out) == 0 && w.Flush() != nil {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+func (h *Hub) drop(c *hubClient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.dropLocked(c)
+}
+
+func (h *Hub) dropLocked(c *hubClient) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.out)
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,154 @@
+package invalidate
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+func startHub(t *testing.T, addr string) (*Hub, string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHub()
+	go h.Serve(l)
+	t.Cleanup(h.Close)
+	return h, l.Addr().String()
+}
+
+func wrap(t *testing.T, addr string, retry time.Duration) *Cache {
+	c := Wrap(lrucache.NewLRUCache(10), addr, Options{RetryInterval: retry})
+	t.Cleanup(c.Close)
+	waitFor(t, c.Client().Connected)
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
+
+func has(c *Cache, key string) func() bool {
+	return func() bool {
+		_, ok := c.GetQuiet(key)
+		return ok
+	}
+}
+
+func not(f func() bool) func() bool {
+	return func() bool { return !f() }
+}
+
+func TestInvalidate(t *testing.T) {
+	t.Parallel()
+	_, addr := startHub(t, "127.0.0.1:0")
+	a, b := wrap(t, addr, 10*time.Millisecond), wrap(t, addr, 10*time.Millisecond)
+
+	b.Cache.Set("k 1", "old", time.Time{})
+	b.Cache.Set("k2", "old", time.Time{})
+	a.Set("k 1", "new", time.Time{})
+	waitFor(t, not(has(b, "k 1")))
+	if v, _ := a.Get("k 1"); v != "new" {
+		t.Error("expecting own value to stay")
+	}
+
+	a.Del("k2")
+	waitFor(t, not(has(b, "k2")))
+
+	// Both ways
+	a.Cache.Set("k3", "old", time.Time{})
+	b.Set("k3", "new", time.Time{})
+	waitFor(t, not(has(a, "k3")))
+	if _, ok := b.Get("k3"); !ok {
+		t.Error("expecting own value to stay")
+	}
+}
+
+func TestHubRestart(t *testing.T) {
+	t.Parallel()
+	h, addr := startHub(t, "127.0.0.1:0")
+	a := wrap(t, addr, 10*time.Millisecond)
+	a.Set("k", "v", time.Time{})
+
+	// New hub, new epoch. Invalidations might have been lost.
+	h.Close()
+	waitFor(t, not(a.Client().Connected))
+	startHub(t, addr)
+	waitFor(t, a.Client().Connected)
+	waitFor(t, func() bool { return a.Len() == 0 })
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+	_, addr := startHub(t, "127.0.0.1:0")
+	a, b := wrap(t, addr, 10*time.Millisecond), wrap(t, addr, 300*time.Millisecond)
+
+	// Missed messages while b was away
+	b.Set("kb", "v", time.Time{})
+	b.Client().lock.Lock()
+	b.Client().conn.Close()
+	b.Client().lock.Unlock()
+	waitFor(t, not(b.Client().Connected))
+	a.Set("ka", "v", time.Time{})
+	waitFor(t, func() bool { return b.Len() == 0 })
+
+	// Messages from a disconnected client are sent later
+	a.Cache.Set("kx", "v", time.Time{})
+	m := Wrap(multilru.NewMultiLRUCache(2, 10), addr, Options{RetryInterval: 10 * time.Millisecond})
+	defer m.Close()
+	m.Del("kx")
+	waitFor(t, not(has(a, "kx")))
+}
+
+func TestStalledHub(t *testing.T) {
+	t.Parallel()
+
+	// Greets and never reads
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("HELLO 1 0\n"))
+		}
+	}()
+
+	c := Subscribe(l.Addr().String(), Options{
+		RetryInterval: time.Hour,
+		WriteTimeout:  50 * time.Millisecond,
+	})
+	defer c.Close()
+	waitFor(t, c.Connected)
+
+	// Fill the socket buffers, then writes time out
+	key := strings.Repeat("k", 64*1024)
+	start := time.Now()
+	for i := 0; i < 500 && c.Connected(); i++ {
+		c.Invalidate(key)
+	}
+	if c.Connected() {
+		t.Error("Expecting writes to fail")
+	}
+	c.Invalidate(key)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expecting Invalidate not to block")
+	}
+}
@@ -0,0 +1,89 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Computes the new value for Update from the current one. `ok` is
+// false if the key is missing or stale. Returning keep=false removes
+// the key. Runs with the cache lock held, so it must not use the
+// cache.
+type UpdateFunc = func(old interface{}, ok bool) (value interface{}, expire time.Time, keep bool)
+
+// Get a fresh entry, removing it if it's stale. Must be called with
+// the write lock held.
+func (b *LRUCache) freshEntry(key string, now time.Time) *entry {
+	e := b.table[key]
+	if e != nil && !e.expire.IsZero() {
+		if now.IsZero() {
+			now = b.clock.Now()
+		}
+		if e.expired(now) {
+			b.removeEntry(e, EvictExpired)
+			return nil
+		}
+	}
+	return e
+}
+
+// Add an item to the cache unless the key is already there. Stale
+// items don't count. True if the item was added, it might not be if
+// it's bigger than MaxSize. O(log(n)) if expiry
+// is set, O(1) when clear.
+func (b *LRUCache) SetIfAbsent(key string, value interface{}, expire time.Time) bool {
+	b.writeLock()
+	defer b.unlock()
+
+	if b.freshEntry(key, time.Time{}) != nil {
+		return false
+	}
+	return b.setNow(key, value, sizeOf(value), time.Time{}, expire, time.Time{})
+}
+
+// Replace the value of a key, but only if the current one is equal
+// to `old`. The expiry times stay the same. Values are compared with
+// ==, which panics for uncomparable ones. True if the value was
+// replaced. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) CompareAndSwap(key string, old, new interface{}) bool {
+	b.writeLock()
+	defer b.unlock()
+
+	e := b.freshEntry(key, time.Time{})
+	if e == nil || e.value != old {
+		return false
+	}
+	tags := e.tags
+	if !b.setNow(key, new, sizeOf(new), e.soft, e.expire, time.Time{}) {
+		return false
+	}
+	b.retag(key, tags)
+	return true
+}
+
+// Atomically replace the value of a key with the one computed by fn
+// from the current one. Returns the value left in the cache, if any.
+// O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) Update(key string, fn UpdateFunc) (value interface{}, ok bool) {
+	b.writeLock()
+	defer b.unlock()
+
+	var old interface{}
+	e := b.freshEntry(key, time.Time{})
+	if e != nil {
+		old = e.value
+	}
+	value, expire, keep := fn(old, e != nil)
+	if !keep {
+		if e != nil {
+			b.removeEntry(e, EvictDeleted)
+		}
+		return nil, false
+	}
+	tags := b.tagsOf(key)
+	if !b.setNow(key, value, sizeOf(value), time.Time{}, expire, time.Time{}) {
+		// Bigger than MaxSize, or no entry for it.
+		return nil, false
+	}
+	b.retag(key, tags)
+	return value, true
+}
@@ -0,0 +1,93 @@
+package lrucache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetIfAbsent(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	if !b.SetIfAbsent("a", "va", time.Time{}) {
+		t.Error("Expecting set")
+	}
+	if b.SetIfAbsent("a", "vb", time.Time{}) {
+		t.Error("Expecting no set")
+	}
+	if v, _ := b.Get("a"); v != "va" {
+		t.Error("Expecting old value")
+	}
+
+	// Stale entries are absent
+	b.Set("b", "vb", time.Now().Add(time.Duration(-1*time.Second)))
+	if !b.SetIfAbsent("b", "vb2", time.Time{}) {
+		t.Error("Expecting set")
+	}
+	if v, _ := b.GetNotStale("b"); v != "vb2" {
+		t.Error("Expecting new value")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	if b.CompareAndSwap("a", nil, "va") {
+		t.Error("Expecting no swap of missing key")
+	}
+	expire := time.Now().Add(time.Hour)
+	b.Set("a", "va", expire)
+	if b.CompareAndSwap("a", "vx", "vb") {
+		t.Error("Expecting no swap")
+	}
+	if !b.CompareAndSwap("a", "va", "vb") {
+		t.Error("Expecting swap")
+	}
+	if v, _ := b.Get("a"); v != "vb" {
+		t.Error("Expecting new value")
+	}
+	var e time.Time
+	b.RangeExpiry(func(key string, value interface{}, expire time.Time) bool {
+		e = expire
+		return true
+	})
+	if !e.Equal(expire) {
+		t.Error("Expecting expiry to stay the same")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	incr := func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		if !ok {
+			return 1, time.Time{}, true
+		}
+		return old.(int) + 1, time.Time{}, true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Update("a", incr)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, ok := b.Get("a"); !ok || v != 400 {
+		t.Errorf("Expecting different value %v", v)
+	}
+
+	v, ok := b.Update("a", func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		return nil, time.Time{}, false
+	})
+	if ok || v != nil || b.Len() != 0 {
+		t.Error("Expecting key to be removed")
+	}
+}
@@ -0,0 +1,130 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of the current time, used for expiry and the janitor. See
+// Options.Clock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Delivers ticks like time.Ticker does.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Clock using the time package.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Clock that only moves when told to, for tests. Tickers fire during
+// Advance and Set, dropping ticks for slow receivers like
+// time.Ticker does.
+type ManualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers map[*manualTicker]struct{}
+}
+
+type manualTicker struct {
+	clock  *ManualClock
+	period time.Duration
+	next   time.Time // time of the next tick
+	c      chan time.Time
+}
+
+// Create a clock showing `now`.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now:     now,
+		tickers: make(map[*manualTicker]struct{}),
+	}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("lrucache: non-positive interval for NewTicker")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &manualTicker{
+		clock:  c,
+		period: d,
+		next:   c.now.Add(d),
+		c:      make(chan time.Time, 1),
+	}
+	c.tickers[t] = struct{}{}
+	return t
+}
+
+// Move the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.setLocked(c.now.Add(d))
+}
+
+// Move the clock to the given time. Moving it back doesn't fire any
+// tickers.
+func (c *ManualClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.setLocked(now)
+}
+
+func (c *ManualClock) setLocked(now time.Time) {
+	c.now = now
+	for t := range c.tickers {
+		if t.next.After(now) {
+			continue
+		}
+		select {
+		case t.c <- now:
+		default:
+		}
+		// First tick after now.
+		n := now.Sub(t.next)/t.period + 1
+		t.next = t.next.Add(n * t.period)
+	}
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	delete(t.clock.tickers, t)
+}
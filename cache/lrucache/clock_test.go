@@ -0,0 +1,117 @@
+package lrucache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	t.Parallel()
+	start := time.Unix(1000, 0)
+	c := NewManualClock(start)
+
+	tk := c.NewTicker(time.Second)
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Error("Not expecting tick")
+	default:
+	}
+
+	// Ticks are dropped, like with time.Ticker
+	c.Advance(3 * time.Second)
+	if now := <-tk.C(); !now.Equal(start.Add(3500 * time.Millisecond)) {
+		t.Error("Expecting tick at current time")
+	}
+	c.Advance(400 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Error("Not expecting tick")
+	default:
+	}
+	c.Advance(100 * time.Millisecond)
+	<-tk.C()
+
+	// Far ahead, the next tick is still one period away
+	c.Advance(1000 * time.Hour)
+	<-tk.C()
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Error("Not expecting tick")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	<-tk.C()
+
+	tk.Stop()
+	c.Set(start.Add(time.Hour))
+	select {
+	case <-tk.C():
+		t.Error("Not expecting tick after Stop")
+	default:
+	}
+	if !c.Now().Equal(start.Add(time.Hour)) {
+		t.Error("Expecting different time")
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	c := NewManualClock(time.Unix(1000, 0))
+	b := NewLRUCacheOptions(10, Options{
+		Clock:           c,
+		JanitorInterval: time.Minute,
+	})
+	defer b.Close()
+
+	b.Set("a", "va", c.Now().Add(time.Second))
+	b.Set("b", "vb", c.Now().Add(2*time.Minute))
+	b.SetSoft("c", "vc", c.Now().Add(time.Second), time.Time{})
+
+	c.Advance(2 * time.Second)
+	if _, ok := b.GetNotStale("a"); ok {
+		t.Error("Expecting a to be stale")
+	}
+	if _, ok := b.GetNotStale("b"); !ok {
+		t.Error("Expecting b to be fresh")
+	}
+	b.Get("c")
+	if s := b.Stats(); s.StaleHits != 1 {
+		t.Error("Expecting stale hit")
+	}
+
+	// Only the janitor removes b
+	time.Sleep(10 * time.Millisecond)
+	if b.Len() != 2 {
+		t.Error("Not expecting janitor to run")
+	}
+	c.Advance(2 * time.Minute)
+	for i := 0; i < 1000 && b.Len() > 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := b.GetQuiet("c"); !ok || b.Len() != 1 {
+		t.Error("Expecting janitor to remove b")
+	}
+}
+
+func TestPoolClock(t *testing.T) {
+	t.Parallel()
+	c := NewManualClock(time.Unix(1000, 0))
+	p := NewPool(2)
+	a := NewLRUCacheOptions(0, Options{Pool: p, Clock: c})
+	b := NewLRUCacheOptions(0, Options{Pool: p, Clock: c})
+
+	// "b" is added later, but looks older
+	a.Set("a", "va", time.Time{})
+	c.Set(time.Unix(500, 0))
+	b.Set("b", "vb", time.Time{})
+	c.Set(time.Unix(2000, 0))
+	b.Set("c", "vc", time.Time{})
+	if a.Len() != 1 || b.Len() != 1 {
+		t.Error("Expecting b to evict its own entry")
+	}
+	if _, ok := b.Get("c"); !ok {
+		t.Error("Expecting hit")
+	}
+}
@@ -0,0 +1,205 @@
+package lrucache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Turns values into bytes and back, used by Dump and Load.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// Default codec, using encoding/gob. Values of custom types must be
+// registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&value)
+	return buf.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var value interface{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// Copy of a single cache entry.
+type Item struct {
+	Key    string
+	Value  interface{}
+	Size   int64
+	Soft   time.Time
+	Expire time.Time
+}
+
+func (e *entry) item() Item {
+	return Item{e.key, e.value, e.size, e.soft, e.expire}
+}
+
+var dumpMagic = []byte("LRUC\x01")
+
+var ErrBadDump = errors.New("lrucache: malformed dump")
+
+// Sanity limit for key and value length in a dump.
+const maxDumpField = 1 << 30
+
+// Copy all the entries, least recently used first. O(n)
+func (b *LRUCache) Items() []Item {
+	b.writeLock()
+	defer b.unlock()
+
+	items := make([]Item, 0, b.usedLen())
+	lists := b.usedLists()
+	for i := len(lists) - 1; i >= 0; i-- {
+		for el := lists[i].Back(); el != nil; el = el.Prev() {
+			items = append(items, el.Value.(*entry).item())
+		}
+	}
+	return items
+}
+
+// Add an entry copied with Items, overwriting existing one if it
+// exists. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) SetItem(it Item) {
+	b.writeLock()
+	defer b.unlock()
+
+	b.setNow(it.Key, it.Value, it.Size, it.Soft, it.Expire, time.Time{})
+}
+
+// Write all the entries to w, in a form that can be read back with
+// Load. Values are encoded with the Codec from Options. The lock is
+// only held while taking a copy of the entries. O(n)
+func (b *LRUCache) Dump(w io.Writer) error {
+	return DumpItems(w, b.codec, b.Items())
+}
+
+// Read entries written by Dump and add them to the cache, keeping
+// their LRU order. Entries that already expired are skipped.
+func (b *LRUCache) Load(r io.Reader) error {
+	now := b.clock.Now()
+	return LoadItems(r, b.codec, func(it Item) {
+		if !it.Expire.IsZero() && it.Expire.Before(now) {
+			return
+		}
+		b.SetItem(it)
+	})
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// Write items in the Dump format.
+func DumpItems(w io.Writer, codec Codec, items []Item) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(dumpMagic)
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	putVarint := func(v int64) {
+		bw.Write(buf[:binary.PutVarint(buf[:], v)])
+	}
+
+	for _, it := range items {
+		data, err := codec.Marshal(it.Value)
+		if err != nil {
+			return fmt.Errorf("lrucache: can't marshal %q: %v", it.Key, err)
+		}
+		bw.WriteByte(1)
+		putUvarint(uint64(len(it.Key)))
+		bw.WriteString(it.Key)
+		putVarint(it.Size)
+		putVarint(unixNano(it.Soft))
+		putVarint(unixNano(it.Expire))
+		putUvarint(uint64(len(data)))
+		bw.Write(data)
+	}
+	bw.WriteByte(0)
+	return bw.Flush()
+}
+
+// Read items in the Dump format, calling fn for each of them in
+// order.
+func LoadItems(r io.Reader, codec Codec, fn func(Item)) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(dumpMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, dumpMagic) {
+		return ErrBadDump
+	}
+
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDumpField {
+			return nil, ErrBadDump
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(br, data)
+		return data, err
+	}
+
+	for {
+		flag, err := br.ReadByte()
+		if err != nil {
+			return ErrBadDump
+		}
+		if flag == 0 {
+			return nil
+		}
+
+		var it Item
+		var key, data []byte
+		var soft, expire int64
+		key, err = readBytes()
+		if err == nil {
+			it.Size, err = binary.ReadVarint(br)
+		}
+		if err == nil {
+			soft, err = binary.ReadVarint(br)
+		}
+		if err == nil {
+			expire, err = binary.ReadVarint(br)
+		}
+		if err == nil {
+			data, err = readBytes()
+		}
+		if err != nil {
+			return ErrBadDump
+		}
+
+		it.Key = string(key)
+		it.Soft = fromUnixNano(soft)
+		it.Expire = fromUnixNano(expire)
+		it.Value, err = codec.Unmarshal(data)
+		if err != nil {
+			return fmt.Errorf("lrucache: can't unmarshal %q: %v", it.Key, err)
+		}
+		fn(it)
+	}
+}
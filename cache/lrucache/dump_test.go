@@ -0,0 +1,54 @@
+package lrucache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDumpLoad(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.SetSize("b", 42, 7, now.Add(time.Hour))
+	b.SetSoft("c", []byte("vc"), now.Add(time.Minute), now.Add(time.Hour))
+	b.Set("d", "vd", now.Add(-time.Second))
+	b.Get("a")
+
+	var buf bytes.Buffer
+	if err := b.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewLRUCache(3)
+	if err := c.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired "d" is dropped
+	if c.Len() != 3 || c.Size() != 7 {
+		t.Error("Expecting different length")
+	}
+	if v, _ := c.GetQuiet("b"); v != 42 {
+		t.Error("Expecting hit")
+	}
+	if v, _ := c.GetQuiet("c"); string(v.([]byte)) != "vc" {
+		t.Error("Expecting hit")
+	}
+
+	// LRU order is kept, "b" goes first
+	c.Set("e", "ve", time.Time{})
+	if _, ok := c.GetQuiet("b"); ok {
+		t.Error("Expecting miss")
+	}
+	items := c.Items()
+	if items[len(items)-2].Key != "a" || !items[0].Expire.Equal(now.Add(time.Hour)) {
+		t.Error("Expecting different order")
+	}
+
+	if err := c.Load(bytes.NewReader([]byte("garbage"))); err != ErrBadDump {
+		t.Error("Expecting error")
+	}
+}
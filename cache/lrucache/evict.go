@@ -0,0 +1,68 @@
+package lrucache
+
+// Why an entry left the cache.
+type EvictReason int
+
+const (
+	EvictExpired  EvictReason = iota // expiry time passed
+	EvictLRU                         // pushed out to make room
+	EvictDeleted                     // removed with Del
+	EvictCleared                     // removed with Clear
+	EvictReplaced                    // overwritten by Set
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictLRU:
+		return "lru"
+	case EvictDeleted:
+		return "deleted"
+	case EvictCleared:
+		return "cleared"
+	case EvictReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// Called for every entry leaving the cache. Never run with the
+// cache lock held, so it's fine to use the cache from within.
+type EvictFunc func(key string, value interface{}, reason EvictReason)
+
+type eviction struct {
+	fn     EvictFunc
+	key    string
+	value  interface{}
+	reason EvictReason
+}
+
+// Remember the entry for the OnEvict callback. Must be called with
+// the lock held, before the entry is cleared.
+func (b *LRUCache) recordEviction(e *entry, reason EvictReason) {
+	if b.onEvict == nil {
+		return
+	}
+	b.evicted = append(b.evicted, eviction{b.onEvict, e.key, e.value, reason})
+}
+
+// Release the lock and only then run the OnEvict callbacks for
+// entries removed while it was held. Pool members give back the
+// entries they freed.
+func (b *LRUCache) unlock() {
+	if b.pool != nil && b.freeList.Len() > 0 {
+		b.pool.put(&b.freeList)
+	}
+	if len(b.evicted) == 0 {
+		b.lock.Unlock()
+		return
+	}
+	evicted := b.evicted
+	b.evicted = nil
+	b.lock.Unlock()
+
+	for _, ev := range evicted {
+		ev.fn(ev.key, ev.value, ev.reason)
+	}
+}
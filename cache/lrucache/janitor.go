@@ -0,0 +1,54 @@
+package lrucache
+
+import (
+	"time"
+)
+
+// Default number of entries removed while holding the lock by
+// ExpireNow and the janitor.
+const defaultExpireBudget = 256
+
+// Remove at most `budget` entries that expire before `now`, holding
+// the lock once. O(budget*log(n))
+func (b *LRUCache) expireSome(now time.Time, budget int) int {
+	b.writeLock()
+	defer b.unlock()
+
+	i := 0
+	for i < budget {
+		e := b.expiredEntry(now)
+		if e == nil {
+			break
+		}
+		b.removeEntry(e, EvictExpired)
+		i += 1
+	}
+	return i
+}
+
+// Background goroutine removing expired entries every `interval`.
+func (b *LRUCache) janitor(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := b.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			b.expireSome(b.clock.Now(), b.expireBudget)
+		}
+	}
+}
+
+// Stop the janitor goroutine, if there is one, and wait for it to
+// finish. The cache stays usable. Safe to call more than once.
+func (b *LRUCache) Close() {
+	b.closeOnce.Do(func() {
+		if b.janitorStop != nil {
+			close(b.janitorStop)
+			<-b.janitorDone
+		}
+	})
+}
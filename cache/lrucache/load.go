@@ -0,0 +1,288 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Produces the value for a key missing from the cache, along with
+// its expiry time.
+type LoadFunc = func() (value interface{}, expire time.Time, err error)
+
+// Like LoadFunc, for GetOrLoadCtx. The loader should give up when
+// ctx is done.
+type LoadCtxFunc = func(ctx context.Context) (value interface{}, expire time.Time, err error)
+
+// Produces a new value for an entry past its soft expiry, along
+// with new soft and hard expiry times.
+type RefreshFunc func(key string) (value interface{}, soft, expire time.Time, err error)
+
+// Matches the error returned to everyone waiting for a loader that
+// panicked, see LoaderPanicError.
+var ErrLoaderPanic = errors.New("lrucache: loader panicked")
+
+// Returned instead of a value when the loader panicked, the panic
+// doesn't propagate. errors.Is(err, ErrLoaderPanic) is true for it.
+type LoaderPanicError struct {
+	Value interface{} // passed to panic
+	Stack []byte      // of the loader goroutine when it panicked
+}
+
+func (e *LoaderPanicError) Error() string {
+	return fmt.Sprintf("%v: %v\n%s", ErrLoaderPanic, e.Value, e.Stack)
+}
+
+func (e *LoaderPanicError) Is(target error) bool {
+	return target == ErrLoaderPanic
+}
+
+// Call from a deferred function only, it recovers the panic.
+func recoverLoader(err *error) {
+	if p := recover(); p != nil {
+		*err = &LoaderPanicError{Value: p, Stack: debug.Stack()}
+	}
+}
+
+// A load in progress. Waiters block on done, after that value and
+// err are read only.
+type call struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	dropped bool // the key was set or removed meanwhile, don't store
+}
+
+// Get a fresh item from the cache, or run the loader to get it and
+// store the result. Concurrent misses on the same key share a single
+// loader run. Loader errors are returned to all the waiters and not
+// cached, unless NegativeTTL is set. If the loader panics, they all
+// get a *LoaderPanicError. There is no deadline, use GetOrLoadCtx for
+// that.
+func (b *LRUCache) GetOrLoad(key string, loader LoadFunc) (value interface{}, err error) {
+	value, c, start, err := b.lookupLoad(key, b.clock.Now())
+	if c == nil {
+		return value, err
+	}
+	if start {
+		b.load(key, c, loader)
+	} else {
+		<-c.done
+	}
+	return c.value, c.err
+}
+
+// Like GetOrLoad, but gives up waiting when ctx is done, returning
+// ctx.Err(). The load itself runs in its own goroutine and goes on
+// for the other waiters. Its context carries the values of ctx, but
+// is not cancelled with it, only after LoadTimeout if that's set.
+// If the loader doesn't return by then all the waiters get
+// context.DeadlineExceeded. Panics are reported like in GetOrLoad.
+func (b *LRUCache) GetOrLoadCtx(ctx context.Context, key string, loader LoadCtxFunc) (value interface{}, err error) {
+	value, c, start, err := b.lookupLoad(key, b.clock.Now())
+	if c == nil {
+		return value, err
+	}
+	if start {
+		go b.loadCtx(context.WithoutCancel(ctx), key, c, loader)
+	}
+	return wait(ctx, c)
+}
+
+// Get a fresh item from the cache. On a miss, if GetOrLoad is
+// loading the key, wait for it, until ctx is done. The error is
+// either the loader error or ctx.Err().
+func (b *LRUCache) GetCtx(ctx context.Context, key string) (value interface{}, ok bool, err error) {
+	now := b.clock.Now()
+	if b.reads != nil {
+		if v, ok, _ := b.getShared(key, true, now); ok {
+			return v, true, nil
+		}
+	}
+
+	b.writeLock()
+
+	b.stats.Gets += 1
+	if e := b.table[key]; e != nil && !e.expired(now) {
+		b.stats.Hits += 1
+		b.touchEntry(e)
+		b.maybeRefresh(e, now)
+		value = e.value
+		b.unlock()
+		return value, true, nil
+	}
+	b.stats.Misses += 1
+	c := b.loads[key]
+	b.unlock()
+
+	if c == nil {
+		return nil, false, nil
+	}
+	value, err = wait(ctx, c)
+	return value, err == nil, err
+}
+
+// Look up a key for GetOrLoad. On a hit or a cached error `c` is nil.
+// Otherwise it's the load to wait for, and if `start` is set the
+// caller must run it.
+func (b *LRUCache) lookupLoad(key string, now time.Time) (value interface{}, c *call, start bool, err error) {
+	if b.reads != nil {
+		if v, ok, _ := b.getShared(key, true, now); ok {
+			return v, nil, false, nil
+		}
+	}
+
+	b.writeLock()
+	defer b.unlock()
+
+	b.stats.Gets += 1
+	e := b.table[key]
+	if e != nil && !e.expired(now) {
+		b.stats.Hits += 1
+		b.touchEntry(e)
+		b.maybeRefresh(e, now)
+		return e.value, nil, false, nil
+	}
+
+	b.stats.Misses += 1
+	if b.negative != nil {
+		if v, ok := b.negative.GetNotStaleNow(key, now); ok {
+			return nil, nil, false, v.(error)
+		}
+	}
+
+	if c = b.loads[key]; c != nil {
+		return nil, c, false, nil
+	}
+	c = &call{done: make(chan struct{})}
+	b.loads[key] = c
+	return nil, c, true, nil
+}
+
+func wait(ctx context.Context, c *call) (value interface{}, err error) {
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Run the loader and publish its result to the cache and to the
+// waiters.
+func (b *LRUCache) load(key string, c *call, loader LoadFunc) {
+	var expire time.Time
+
+	func() {
+		defer recoverLoader(&c.err)
+		c.value, expire, c.err = loader()
+	}()
+	b.finishLoad(key, c, expire)
+}
+
+// Run a LoadCtxFunc, with LoadTimeout applied.
+func (b *LRUCache) loadCtx(ctx context.Context, key string, c *call, loader LoadCtxFunc) {
+	if b.loadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.loadTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		value  interface{}
+		expire time.Time
+		err    error
+	}
+	// Buffered, the loader might return after we stopped waiting.
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			done <- r
+		}()
+		defer recoverLoader(&r.err)
+		r.value, r.expire, r.err = loader(ctx)
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		// Only the timeout, cancellation of the caller's
+		// context doesn't get here.
+		r = result{err: ctx.Err()}
+	}
+	c.value, c.err = r.value, r.err
+	b.finishLoad(key, c, r.expire)
+}
+
+// Publish the result of a load to the cache and to the waiters.
+func (b *LRUCache) finishLoad(key string, c *call, expire time.Time) {
+	b.writeLock()
+	delete(b.loads, key)
+	switch {
+	case c.dropped:
+	case c.err == nil:
+		// Replacing a stale entry keeps its tags.
+		tags := b.tagsOf(key)
+		b.setNow(key, c.value, sizeOf(c.value), time.Time{}, expire, time.Time{})
+		b.retag(key, tags)
+	case b.negative != nil:
+		b.negative.Set(key, c.err, b.clock.Now().Add(b.negativeTTL))
+	}
+	b.unlock()
+	close(c.done)
+}
+
+// Make a load of the key in progress, if any, forget its result.
+// The key was set or removed, the result would bring back an older
+// value. Must be called with the lock held.
+func (b *LRUCache) dropLoad(key string) {
+	if c := b.loads[key]; c != nil {
+		c.dropped = true
+	}
+}
+
+// Called on a hit. If the entry is past its soft expiry count a
+// stale hit and start a background refresh, unless somebody is
+// loading it already. Must be called with the lock held.
+func (b *LRUCache) maybeRefresh(e *entry, now time.Time) {
+	if e.soft.IsZero() || !e.soft.Before(now) {
+		return
+	}
+	b.stats.StaleHits += 1
+	if b.refresh == nil || b.loads[e.key] != nil {
+		return
+	}
+	c := &call{done: make(chan struct{})}
+	b.loads[e.key] = c
+	go b.refreshEntry(e.key, c)
+}
+
+// Run the Refresh loader. On success the new value replaces the
+// stale one, on failure the stale one stays in place. If NegativeTTL
+// is set the next attempt is postponed by that much.
+func (b *LRUCache) refreshEntry(key string, c *call) {
+	var soft, expire time.Time
+
+	func() {
+		defer recoverLoader(&c.err)
+		c.value, soft, expire, c.err = b.refresh(key)
+	}()
+
+	b.writeLock()
+	delete(b.loads, key)
+	if c.dropped || b.table[key] == nil {
+		// Stale value already replaced, removed or evicted.
+	} else if c.err == nil {
+		tags := b.tagsOf(key)
+		b.setNow(key, c.value, sizeOf(c.value), soft, expire, time.Time{})
+		b.retag(key, tags)
+	} else if e := b.table[key]; e != nil && b.negativeTTL > 0 {
+		e.soft = b.clock.Now().Add(b.negativeTTL)
+	}
+	b.unlock()
+	close(c.done)
+}
@@ -0,0 +1,405 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	var calls int32
+	release := make(chan bool)
+	loader := func() (interface{}, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "va", time.Time{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := b.GetOrLoad("a", loader); v != "va" || err != nil {
+				t.Error("Expecting value")
+			}
+		}()
+	}
+	// Let the goroutines pile up on the loader
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expecting single loader call, got %v", calls)
+	}
+	if v, _ := b.GetNotStale("a"); v != "va" {
+		t.Error("Expecting hit")
+	}
+
+	// Stale entries are reloaded
+	b.Set("b", "old", time.Now().Add(time.Duration(-1*time.Second)))
+	v, _ := b.GetOrLoad("b", func() (interface{}, time.Time, error) {
+		return "new", time.Time{}, nil
+	})
+	if v != "new" {
+		t.Error("Expecting reload")
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	errFail := errors.New("fail")
+	calls := 0
+	loader := func() (interface{}, time.Time, error) {
+		calls += 1
+		return nil, time.Time{}, errFail
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetOrLoad("a", loader); err != errFail {
+			t.Error("Expecting error")
+		}
+	}
+	if calls != 2 || b.Len() != 0 {
+		t.Error("Not expecting error to be cached")
+	}
+
+	_, err := b.GetOrLoad("a", func() (interface{}, time.Time, error) {
+		panic("boom")
+	})
+	var perr *LoaderPanicError
+	if !errors.Is(err, ErrLoaderPanic) || !errors.As(err, &perr) || perr.Value != "boom" {
+		t.Error("Expecting panic error")
+	}
+	if !strings.Contains(err.Error(), "TestGetOrLoadError") {
+		t.Error("Expecting stack in the error")
+	}
+	if _, err := b.GetOrLoad("a", loader); err != errFail {
+		t.Error("Expecting loader to run again")
+	}
+}
+
+func TestGetOrLoadChanged(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	started := make(chan bool)
+	release := make(chan bool)
+	loader := func() (interface{}, time.Time, error) {
+		started <- true
+		<-release
+		return "loaded", time.Time{}, nil
+	}
+	load := func(key string, change func()) interface{} {
+		done := make(chan interface{})
+		go func() {
+			v, _ := b.GetOrLoad(key, loader)
+			done <- v
+		}()
+		<-started
+		change()
+		release <- true
+		return <-done
+	}
+
+	// Waiters get the result, the cache doesn't keep it
+	if v := load("a", func() { b.Del("a") }); v != "loaded" {
+		t.Error("Expecting loaded value")
+	}
+	if _, ok := b.Get("a"); ok {
+		t.Error("Expecting Del to win over the load")
+	}
+
+	load("a", func() { b.Set("a", "set", time.Time{}) })
+	if v, _ := b.Get("a"); v != "set" {
+		t.Error("Expecting Set to win over the load")
+	}
+
+	load("b", func() { b.Clear() })
+	load("c", func() { b.InvalidatePrefix("c") })
+	if b.Len() != 0 {
+		t.Error("Expecting Clear and InvalidatePrefix to win over the load")
+	}
+}
+
+func TestGetOrLoadCtx(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	type key struct{}
+	release := make(chan bool)
+	loader := func(ctx context.Context) (interface{}, time.Time, error) {
+		<-release
+		if ctx.Err() != nil {
+			t.Error("Expecting load to go on")
+		}
+		return ctx.Value(key{}), time.Time{}, nil
+	}
+
+	// The caller gives up, the load goes on
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "va"))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := b.GetOrLoadCtx(ctx, "a", loader); err != context.Canceled {
+		t.Error("Expecting cancel")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := b.GetCtx(ctx, "a"); err != context.DeadlineExceeded {
+		t.Error("Expecting GetCtx to wait for load")
+	}
+
+	done := make(chan bool)
+	go func() {
+		v, ok, err := b.GetCtx(context.Background(), "a")
+		if v != "va" || !ok || err != nil {
+			t.Error("Expecting loaded value")
+		}
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+
+	if v, ok, _ := b.GetCtx(context.Background(), "a"); v != "va" || !ok {
+		t.Error("Expecting hit")
+	}
+	if _, ok, err := b.GetCtx(context.Background(), "b"); ok || err != nil {
+		t.Error("Expecting miss")
+	}
+
+	// Panics are reported to everyone
+	_, err := b.GetOrLoadCtx(context.Background(), "b", func(ctx context.Context) (interface{}, time.Time, error) {
+		panic("boom")
+	})
+	var perr *LoaderPanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" {
+		t.Error("Expecting panic error")
+	}
+}
+
+func TestLoadTimeout(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCacheOptions(3, Options{LoadTimeout: 10 * time.Millisecond})
+
+	// The loader gives up by itself
+	_, err := b.GetOrLoadCtx(context.Background(), "a", func(ctx context.Context) (interface{}, time.Time, error) {
+		<-ctx.Done()
+		return nil, time.Time{}, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error("Expecting timeout")
+	}
+
+	// Or it doesn't, and is abandoned
+	release := make(chan bool)
+	defer close(release)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := b.GetOrLoadCtx(context.Background(), "b", func(ctx context.Context) (interface{}, time.Time, error) {
+				<-release
+				return "vb", time.Time{}, nil
+			})
+			if err != context.DeadlineExceeded {
+				t.Error("Expecting timeout")
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := b.Get("b"); ok {
+		t.Error("Expecting miss")
+	}
+}
+
+func TestGetOrLoadNegative(t *testing.T) {
+	t.Parallel()
+
+	// Pool members have no capacity of their own
+	for _, b := range []*LRUCache{
+		NewLRUCacheOptions(3, Options{NegativeTTL: time.Hour}),
+		NewLRUCacheOptions(0, Options{NegativeTTL: time.Hour, Pool: NewPool(3)}),
+	} {
+		errFail := errors.New("fail")
+		calls := 0
+		loader := func() (interface{}, time.Time, error) {
+			calls += 1
+			return nil, time.Time{}, errFail
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := b.GetOrLoad("a", loader); err != errFail {
+				t.Error("Expecting error")
+			}
+		}
+		if calls != 1 {
+			t.Error("Expecting error to be cached")
+		}
+
+		b.Del("a")
+		b.GetOrLoad("a", loader)
+		if calls != 2 {
+			t.Error("Expecting Del to forget the error")
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	release := make(chan bool)
+	refresh := func(key string) (interface{}, time.Time, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		now := time.Now()
+		return "new", now.Add(time.Hour), now.Add(2 * time.Hour), nil
+	}
+	b := NewLRUCacheOptions(3, Options{Refresh: refresh})
+
+	now := time.Now()
+	b.SetSoft("a", "old", now.Add(-time.Second), now.Add(time.Hour))
+	b.SetSoft("b", "old", now.Add(-2*time.Second), now.Add(-time.Second))
+
+	// Stale value served right away
+	if v, _ := b.GetNotStale("a"); v != "old" {
+		t.Error("Expecting stale value")
+	}
+	if v, _ := b.Get("a"); v != "old" {
+		t.Error("Expecting stale value")
+	}
+	close(release)
+	waitLoad(t, b, "a")
+	if calls != 1 {
+		t.Error("Expecting single refresh")
+	}
+	if v, _ := b.GetNotStale("a"); v != "new" {
+		t.Error("Expecting refreshed value")
+	}
+
+	// Past hard expiry it's a miss
+	if _, ok := b.GetNotStale("b"); ok {
+		t.Error("Expecting miss")
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	t.Parallel()
+
+	errFail := errors.New("fail")
+	refreshed := make(chan bool, 10)
+	refresh := func(key string) (interface{}, time.Time, time.Time, error) {
+		refreshed <- true
+		return nil, time.Time{}, time.Time{}, errFail
+	}
+	b := NewLRUCacheOptions(3, Options{Refresh: refresh})
+
+	now := time.Now()
+	b.SetSoft("a", "old", now.Add(-time.Second), now.Add(time.Hour))
+	b.Get("a")
+	waitLoad(t, b, "a")
+
+	// The old value stays, next read tries again
+	if v, _ := b.GetOrLoad("a", nil); v != "old" {
+		t.Error("Expecting stale value")
+	}
+	waitLoad(t, b, "a")
+	if len(refreshed) != 2 {
+		t.Error("Expecting second refresh")
+	}
+}
+
+func TestRefreshChanged(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan bool)
+	refresh := func(key string) (interface{}, time.Time, time.Time, error) {
+		<-release
+		return "new", time.Time{}, time.Time{}, nil
+	}
+	b := NewLRUCacheOptions(3, Options{Refresh: refresh})
+
+	now := time.Now()
+	b.SetSoft("a", "old", now.Add(-time.Second), time.Time{})
+	b.Get("a")
+	b.Del("a")
+	release <- true
+	waitLoad(t, b, "a")
+	if _, ok := b.Get("a"); ok {
+		t.Error("Expecting Del to win over the refresh")
+	}
+
+	b.SetSoft("a", "old", now.Add(-time.Second), time.Time{})
+	b.Get("a")
+	b.Set("a", "set", time.Time{})
+	release <- true
+	waitLoad(t, b, "a")
+	if v, _ := b.Get("a"); v != "set" {
+		t.Error("Expecting Set to win over the refresh")
+	}
+
+	b.SetSoft("a", "old", now.Add(-time.Second), time.Time{})
+	b.Get("a")
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	release <- true
+	waitLoad(t, b, "a")
+	if _, ok := b.GetQuiet("a"); ok || b.Len() != 3 {
+		t.Error("Expecting eviction to win over the refresh")
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan bool)
+	refresh := func(key string) (interface{}, time.Time, time.Time, error) {
+		<-release
+		return "new", time.Time{}, time.Time{}, nil
+	}
+	c := NewManualClock(time.Unix(1000, 0))
+	b := NewLRUCacheOptions(3, Options{Refresh: refresh, Clock: c})
+
+	b.SetSoft("a", "old", c.Now().Add(-time.Second), c.Now().Add(time.Second))
+	b.Get("a")
+	c.Advance(2 * time.Second)
+	if _, ok := b.GetNotStale("a"); ok {
+		t.Error("Expecting miss")
+	}
+	release <- true
+	waitLoad(t, b, "a")
+	if _, ok := b.GetQuiet("a"); ok {
+		t.Error("Expecting expiry to win over the refresh")
+	}
+}
+
+// Wait until the load or refresh of the key is over, including
+// storing its result.
+func waitLoad(t *testing.T, b *LRUCache, key string) {
+	for i := 0; i < 1000; i++ {
+		b.writeLock()
+		c := b.loads[key]
+		b.unlock()
+		if c == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Expecting load to finish")
+}
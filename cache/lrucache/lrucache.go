@@ -5,11 +5,15 @@
 //  - Avoids dynamic memory allocations. All memory is allocated
 //    on creation.
 //  - Access is O(1). Modification O(log(n)) if expiry is set, O(1) if expiry is zero.
-//  - Multithreading supported using a mutex lock.
+//  - Multithreading supported using a read-write lock. Optionally
+//    hits only take the read lock, see reads.go.
+//  - Optionally bounded by the total size of values, not only by
+//    the number of entries.
 //
 // Every element in the cache is linked to three data structures:
 // `table` map, `priorityQueue` ordered by expiry and `lruList`
-// ordered by decreasing popularity.
+// ordered by decreasing popularity. Scan resistant policies split
+// the `lruList` into segments, see policy.go.
 
 package lrucache
 
@@ -24,32 +28,203 @@ type entry struct {
 	key     string      // key is a key!
 	value   interface{} //
 	expire  time.Time   // time when the item is expired. it's okay to be stale.
+	soft    time.Time   // time when the item should be refreshed, zero if never
+	touched int64       // last access, only tracked for Pool members
+	size    int64       // cost of the item counted against maxSize
+	tags    []string    // set with SetTags, indexed in `tags` of the cache
 	index   int         // index for priority queue needs. -1 if entry is free
 }
 
+// Is the entry past its expiry time? Entries without expiry never are.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && e.expire.Before(now)
+}
+
+// Values implementing Sizer report their own cost when added with
+// Set. Same as the vitess cache expects.
+type Sizer interface {
+	Size() int
+}
+
+// Optional settings for the LRU cache. The zero value gives a cache
+// bounded only by the number of entries.
+type Options struct {
+	// Upper limit for the sum of sizes of all the entries. Least
+	// used entries are evicted to stay under it. Zero means no
+	// limit.
+	MaxSize int64
+
+	// Called for every entry leaving the cache, outside of the
+	// cache lock.
+	OnEvict EvictFunc
+
+	// How long GetOrLoad remembers loader errors. Zero means
+	// errors are not cached.
+	NegativeTTL time.Duration
+
+	// Deadline of loads started by GetOrLoadCtx. Zero means no
+	// deadline. Doesn't apply to GetOrLoad, its loader runs in the
+	// caller's goroutine for as long as it takes.
+	LoadTimeout time.Duration
+
+	// Number of loader errors remembered. Defaults to the
+	// capacity of the cache, or of the Pool for its members.
+	NegativeCapacity uint
+
+	// Used to refresh entries added with SetSoft in the
+	// background, once they are past their soft expiry.
+	Refresh RefreshFunc
+
+	// Encodes values for Dump and Load. GobCodec if not set.
+	Codec Codec
+
+	// Eviction policy, PolicyLRU if not set.
+	Policy Policy
+
+	// How often a background goroutine removes expired entries.
+	// Zero means no janitor. Call Close to stop it.
+	JanitorInterval time.Duration
+
+	// Max number of entries removed while holding the lock, by
+	// the janitor on every tick and by Expire. Defaults to 256.
+	ExpireBudget int
+
+	// Let hits take only the read lock and update the LRU order
+	// lazily. Scales much better with many readers, at the cost of
+	// slightly less accurate eviction. Ignored for Pool members,
+	// their entries can move to another cache at any time.
+	BufferedReads bool
+
+	// Take entries from a pool shared with other caches, instead
+	// of using a fixed number of own entries. If the pool is
+	// exhausted, the cache has no entries of its own and the other
+	// members are busy, an item is not stored. SetIfAbsent and
+	// Update report that. The pool uses the Clock of its first
+	// member.
+	Pool *Pool
+
+	// Source of the current time for methods that don't take it
+	// as an argument, and of ticks for the janitor. Defaults to
+	// RealClock. Tests can use a ManualClock.
+	Clock Clock
+
+	// Sliding expiration. Every GetNotStale hit on an entry with
+	// expiry set pushes the expiry to at least IdleTimeout from
+	// now, so entries only go stale when not read for a while.
+	IdleTimeout time.Duration
+}
+
 type LRUCache struct {
-	lock          sync.Mutex
+	lock          sync.RWMutex
 	table         map[string]*entry // all entries in table must be in lruList
 	priorityQueue PriorityQueue     // some elements from table may be in priorityQueue
 	lruList       List              // every entry is either used and resides in lruList
+	protected     List              // (or protected or window, depending on policy)
+	window        List              //
 	freeList      List              // or free and is linked to freeList
+	policy        Policy            // how the used lists are managed
+	protectedCap  int               // max length of protected list
+	windowCap     int               // max length of window list
+	sketch        *sketch           // access frequency for PolicyTinyLFU
+	size          int64             // sum of sizes of used entries
+	maxSize       int64             // limit for size, 0 if not set
+	onEvict       EvictFunc         // callback for removed entries, may be nil
+	evicted       []eviction        // removed entries waiting for onEvict
+	loads         map[string]*call  // loads in progress for GetOrLoad(Ctx)
+	negative      *LRUCache         // recent loader errors, nil if not cached
+	negativeTTL   time.Duration     // how long to keep loader errors
+	loadTimeout   time.Duration     // deadline of GetOrLoadCtx loads
+	clock         Clock             // source of time for the plain methods
+	refresh       RefreshFunc       // loader for stale entries, may be nil
+	stats         Stats             // counters, updated under the lock
+	codec         Codec             // for Dump and Load
+	expireBudget  int               // max removals per lock in Expire
+	janitorStop   chan struct{}     // closed to stop the janitor
+	janitorDone   chan struct{}     // closed when the janitor is gone
+	closeOnce     sync.Once         //
+	reads         []readBuffer      // buffered hits, nil if not enabled
+	readMask      uint32            // len(reads)-1
+	pool          *Pool             // shared entries, may be nil
+	idleTimeout   time.Duration     // sliding expiry for GetNotStale
+	tags          tagIndex          // entries by tag
 }
 
 // Initialize the LRU cache instance. O(capacity)
 func (b *LRUCache) Init(capacity uint) {
+	b.InitOptions(capacity, Options{})
+}
+
+// Initialize the LRU cache instance with extra settings. O(capacity)
+func (b *LRUCache) InitOptions(capacity uint, o Options) {
 	b.table = make(map[string]*entry, capacity)
 	b.priorityQueue = make([]*entry, 0, capacity)
 	b.lruList.Init()
 	b.freeList.Init()
 	heap.Init(&b.priorityQueue)
+	b.pool = o.Pool
+	if b.pool != nil {
+		// Segment sizes are relative to what we could get.
+		b.initPolicy(o.Policy, uint(b.pool.Capacity())+capacity)
+	} else {
+		b.initPolicy(o.Policy, capacity)
+	}
+	b.initReads(o.BufferedReads && b.pool == nil)
+	b.size = 0
+	b.maxSize = o.MaxSize
+	b.onEvict = o.OnEvict
+	b.evicted = nil
+	b.loads = make(map[string]*call)
+	b.tags = make(tagIndex)
+	b.negative = nil
+	b.negativeTTL = o.NegativeTTL
+	b.loadTimeout = o.LoadTimeout
+	b.clock = o.Clock
+	if b.clock == nil {
+		b.clock = RealClock{}
+	}
+	b.idleTimeout = o.IdleTimeout
+	b.refresh = o.Refresh
+	b.stats = Stats{}
+	b.codec = o.Codec
+	if b.codec == nil {
+		b.codec = GobCodec{}
+	}
+	b.expireBudget = o.ExpireBudget
+	if b.expireBudget <= 0 {
+		b.expireBudget = defaultExpireBudget
+	}
+	if o.NegativeTTL > 0 {
+		n := o.NegativeCapacity
+		if n == 0 && b.pool != nil {
+			n = uint(b.pool.Capacity())
+		} else if n == 0 {
+			n = capacity
+		}
+		b.negative = NewLRUCacheOptions(n, Options{Clock: b.clock})
+	}
+
+	allocEntries(capacity, &b.freeList)
+	if b.pool != nil {
+		b.pool.put(&b.freeList)
+		b.pool.join(b)
+	}
+
+	if o.JanitorInterval > 0 {
+		b.janitorStop = make(chan struct{})
+		b.janitorDone = make(chan struct{})
+		go b.janitor(o.JanitorInterval, b.janitorStop, b.janitorDone)
+	}
+}
 
-	// Reserve all the entries in one giant continous block of memory
+// Reserve all the entries in one giant continous block of memory
+// and link them to the list.
+func allocEntries(capacity uint, l *List) {
 	arrayOfEntries := make([]entry, capacity)
 	for i := uint(0); i < capacity; i++ {
 		e := &arrayOfEntries[i]
 		e.element.Value = e
 		e.index = -1
-		b.freeList.PushElementBack(&e.element)
+		l.PushElementBack(&e.element)
 	}
 }
 
@@ -60,6 +235,13 @@ func NewLRUCache(capacity uint) *LRUCache {
 	return b
 }
 
+// Create new LRU cache instance with extra settings. O(capacity)
+func NewLRUCacheOptions(capacity uint, o Options) *LRUCache {
+	b := &LRUCache{}
+	b.InitOptions(capacity, o)
+	return b
+}
+
 // Give me the entry with lowest expiry field if it's before now.
 func (b *LRUCache) expiredEntry(now time.Time) *entry {
 	if len(b.priorityQueue) == 0 {
@@ -68,7 +250,7 @@ func (b *LRUCache) expiredEntry(now time.Time) *entry {
 
 	if now.IsZero() {
 		// Fill it only when actually used.
-		now = time.Now()
+		now = b.clock.Now()
 	}
 	e := b.priorityQueue[0]
 	if e.expire.Before(now) {
@@ -77,42 +259,99 @@ func (b *LRUCache) expiredEntry(now time.Time) *entry {
 	return nil
 }
 
-// Give me the least loved entry.
-func (b *LRUCache) leastUsedEntry() *entry {
-	return b.lruList.Back().Value.(*entry)
+// Give me the entry that should go first: expired or least loved.
+func (b *LRUCache) victimEntry(now time.Time) (*entry, EvictReason) {
+	e := b.expiredEntry(now)
+	if e != nil {
+		return e, EvictExpired
+	}
+
+	if b.usedLen() == 0 {
+		return nil, EvictLRU
+	}
+
+	return b.leastUsedEntry(), EvictLRU
 }
 
-func (b *LRUCache) freeSomeEntry(now time.Time) (e *entry, used bool) {
+func (b *LRUCache) freeSomeEntry(now time.Time) (e *entry, used bool, reason EvictReason) {
 	if b.freeList.Len() > 0 {
-		return b.freeList.Front().Value.(*entry), false
+		return b.freeList.Front().Value.(*entry), false, EvictLRU
 	}
 
-	e = b.expiredEntry(now)
-	if e != nil {
-		return e, true
+	if b.pool != nil {
+		if e = b.pool.get(&b.freeList); e != nil {
+			return e, false, EvictLRU
+		}
 	}
 
-	if b.lruList.Len() == 0 {
-		return nil, false
+	e, reason = b.victimEntry(now)
+	if b.pool != nil && (e == nil || reason == EvictLRU) {
+		// Maybe somebody else has an older one. With nothing
+		// here and the others busy, the item is dropped rather
+		// than wait for them with the lock held.
+		if s := b.pool.steal(b, e); s != nil {
+			return s, false, EvictLRU
+		}
 	}
+	return e, e != nil, reason
+}
 
-	return b.leastUsedEntry(), true
+// Evict entries until there is room for `size` more. Only
+// meaningful if maxSize is set.
+func (b *LRUCache) freeSomeSize(size int64, now time.Time) {
+	for b.size+size > b.maxSize {
+		b.removeEntry(b.victimEntry(now))
+	}
 }
 
 // Move entry from used/lru list to a free list. Clear the entry as well.
-func (b *LRUCache) removeEntry(e *entry) {
-	if e.element.list != &b.lruList {
+func (b *LRUCache) removeEntry(e *entry, reason EvictReason) {
+	if !b.isUsed(e) {
 		panic("list lruList")
 	}
 
+	b.recordEviction(e, reason)
+	switch reason {
+	case EvictExpired:
+		b.stats.Expired += 1
+	case EvictLRU:
+		b.stats.Evicted += 1
+	case EvictDeleted, EvictCleared:
+		b.dropLoad(e.key)
+	}
+
 	if e.index != -1 {
 		heap.Remove(&b.priorityQueue, e.index)
 	}
-	b.lruList.Remove(&e.element)
+	l := e.element.list
+	l.Remove(&e.element)
 	b.freeList.PushElementFront(&e.element)
+	if reason == EvictLRU && l != &b.window {
+		b.admitWindow()
+	}
 	delete(b.table, e.key)
+	b.size -= e.size
 	e.key = ""
 	e.value = nil
+	e.size = 0
+	e.soft = time.Time{}
+	if e.tags != nil {
+		b.untagEntry(e)
+	}
+}
+
+// Change expiry of a used entry, moving it within priorityQueue in
+// place. O(log(n))
+func (b *LRUCache) setExpire(e *entry, expire time.Time) {
+	e.expire = expire
+	switch {
+	case e.index != -1 && !expire.IsZero():
+		heap.Fix(&b.priorityQueue, e.index)
+	case e.index != -1:
+		heap.Remove(&b.priorityQueue, e.index)
+	case !expire.IsZero():
+		heap.Push(&b.priorityQueue, e)
+	}
 }
 
 func (b *LRUCache) insertEntry(e *entry) {
@@ -124,42 +363,76 @@ func (b *LRUCache) insertEntry(e *entry) {
 		heap.Push(&b.priorityQueue, e)
 	}
 	b.freeList.Remove(&e.element)
-	b.lruList.PushElementFront(&e.element)
+	b.pushUsed(e)
+	if b.pool != nil {
+		e.touched = b.pool.now()
+	}
 	b.table[e.key] = e
+	b.size += e.size
 }
 
-func (b *LRUCache) touchEntry(e *entry) {
-	b.lruList.Remove(&e.element)
-	b.lruList.PushElementFront(&e.element)
+func sizeOf(value interface{}) int64 {
+	if s, ok := value.(Sizer); ok {
+		return int64(s.Size())
+	}
+	return 0
 }
 
-// Add an item to the cache overwriting existing one if it
-// exists. Allows specifing current time required to expire an
-// item when no more slots are used. Value must not be
-// nil. O(log(n)) if expiry is set, O(1) when clear.
-func (b *LRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+// Add or replace an item. False if it wasn't stored: it's bigger
+// than MaxSize, or there was no entry for it.
+func (b *LRUCache) setNow(key string, value interface{}, size int64, soft, expire time.Time, now time.Time) bool {
+	if size < 0 {
+		size = 0
+	}
+	b.stats.Sets += 1
+	b.dropLoad(key)
 
 	var used bool
+	reason := EvictReplaced
 
 	e := b.table[key]
+	if b.maxSize > 0 && size > b.maxSize {
+		// Will never fit. Don't leave the old value behind.
+		if e != nil {
+			b.removeEntry(e, EvictReplaced)
+		}
+		return false
+	}
+
 	if e != nil {
 		used = true
 	} else {
-		e, used = b.freeSomeEntry(now)
+		e, used, reason = b.freeSomeEntry(now)
 		if e == nil {
-			return
+			return false
 		}
 	}
 	if used {
-		b.removeEntry(e)
+		b.removeEntry(e, reason)
+	}
+	if b.maxSize > 0 {
+		b.freeSomeSize(size, now)
 	}
 
 	e.key = key
 	e.value = value
 	e.expire = expire
+	e.soft = soft
+	e.size = size
 	b.insertEntry(e)
+	return true
+}
+
+// Add an item to the cache overwriting existing one if it
+// exists. Allows specifing current time required to expire an
+// item when no more slots are used. If the value implements Sizer
+// its size is counted against MaxSize. O(log(n)) if expiry is
+// set, O(1) when clear.
+func (b *LRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
+	b.writeLock()
+	defer b.unlock()
+
+	b.setNow(key, value, sizeOf(value), time.Time{}, expire, now)
 }
 
 // Add an item to the cache overwriting existing one if it
@@ -168,24 +441,61 @@ func (b *LRUCache) Set(key string, value interface{}, expire time.Time) {
 	b.SetNow(key, value, expire, time.Time{})
 }
 
+// Add an item of a given size to the cache overwriting existing one
+// if it exists. Least used items are evicted until the total size
+// fits in MaxSize. Items bigger than MaxSize are not stored at
+// all. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) SetSize(key string, value interface{}, size int64, expire time.Time) {
+	b.writeLock()
+	defer b.unlock()
+
+	b.setNow(key, value, size, time.Time{}, expire, time.Time{})
+}
+
+// Add an item to the cache with two expiry times. After the `soft`
+// one reads still return the item, but trigger a refresh in the
+// background using the Refresh loader. After `expire` the item is
+// stale as usual. O(log(n)) if expiry is set, O(1) when clear.
+func (b *LRUCache) SetSoft(key string, value interface{}, soft, expire time.Time) {
+	b.writeLock()
+	defer b.unlock()
+
+	b.setNow(key, value, sizeOf(value), soft, expire, time.Time{})
+}
+
 // Get a key from the cache, possibly stale. Update its LRU score. O(1)
 func (b *LRUCache) Get(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	if b.reads != nil {
+		if v, ok, done := b.getShared(key, false, time.Time{}); done {
+			if !ok {
+				b.readStripe().misses.Add(1)
+			}
+			return v, ok
+		}
+	}
 
+	b.writeLock()
+	defer b.unlock()
+
+	b.stats.Gets += 1
 	e := b.table[key]
 	if e == nil {
+		b.stats.Misses += 1
 		return nil, false
 	}
 
+	b.stats.Hits += 1
 	b.touchEntry(e)
+	if !e.soft.IsZero() {
+		b.maybeRefresh(e, b.clock.Now())
+	}
 	return e.value, true
 }
 
 // Get a key from the cache, possibly stale. Don't modify its LRU score. O(1)
 func (b *LRUCache) GetQuiet(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 
 	e := b.table[key]
 	if e == nil {
@@ -198,33 +508,72 @@ func (b *LRUCache) GetQuiet(key string) (v interface{}, ok bool) {
 // Get a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
 func (b *LRUCache) GetNotStale(key string) (value interface{}, ok bool) {
-	return b.GetNotStaleNow(key, time.Now())
+	return b.GetNotStaleNow(key, b.clock.Now())
 }
 
 // Get a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
 func (b *LRUCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	if b.reads != nil {
+		if v, ok, done := b.getShared(key, true, now); done {
+			if !ok {
+				b.readStripe().misses.Add(1)
+			}
+			return v, ok
+		}
+	}
+
+	b.writeLock()
+	defer b.unlock()
 
+	b.stats.Gets += 1
 	e := b.table[key]
 	if e == nil {
+		b.stats.Misses += 1
 		return nil, false
 	}
 
-	if e.expire.Before(now) {
-		b.removeEntry(e)
+	if e.expired(now) {
+		b.removeEntry(e, EvictExpired)
+		b.stats.Misses += 1
 		return nil, false
 	}
 
+	b.stats.Hits += 1
 	b.touchEntry(e)
+	if b.idleTimeout > 0 && !e.expire.IsZero() {
+		if idle := now.Add(b.idleTimeout); e.expire.Before(idle) {
+			b.setExpire(e, idle)
+		}
+	}
+	b.maybeRefresh(e, now)
 	return e.value, true
 }
 
+// Set a new expiry time of a key, zero to never expire. Stale keys
+// can't be revived. Doesn't change the LRU score. True if the key
+// was found. O(log(n))
+func (b *LRUCache) Touch(key string, expire time.Time) bool {
+	b.writeLock()
+	defer b.unlock()
+
+	e := b.freshEntry(key, time.Time{})
+	if e == nil {
+		return false
+	}
+	b.setExpire(e, expire)
+	return true
+}
+
 // Get and remove a key from the cache. O(log(n)) if the item is using expiry, O(1) otherwise.
 func (b *LRUCache) Del(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	b.writeLock()
+	defer b.unlock()
+
+	if b.negative != nil {
+		b.negative.Del(key)
+	}
+	b.dropLoad(key)
 
 	e := b.table[key]
 	if e == nil {
@@ -232,66 +581,87 @@ func (b *LRUCache) Del(key string) (v interface{}, ok bool) {
 	}
 
 	value := e.value
-	b.removeEntry(e)
+	b.removeEntry(e, EvictDeleted)
 	return value, true
 }
 
 // Evict all items from the cache. O(n*log(n))
 func (b *LRUCache) Clear() int {
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	b.writeLock()
+	defer b.unlock()
+
+	if b.negative != nil {
+		b.negative.Clear()
+	}
+	for key := range b.loads {
+		b.dropLoad(key)
+	}
 
 	// First, remove entries that have expiry set
 	l := len(b.priorityQueue)
 	for i := 0; i < l; i++ {
 		// This could be reduced to O(n).
-		b.removeEntry(b.priorityQueue[0])
+		b.removeEntry(b.priorityQueue[0], EvictCleared)
 	}
 
 	// Second, remove all remaining entries
-	r := b.lruList.Len()
+	r := b.usedLen()
 	for i := 0; i < r; i++ {
-		b.removeEntry(b.leastUsedEntry())
+		b.removeEntry(b.backEntry(), EvictCleared)
 	}
 	return l + r
 }
 
-// Evict all the expired items. O(n*log(n))
+// Evict all the expired items. O(n*log(n)), but the lock is
+// released every ExpireBudget entries.
 func (b *LRUCache) Expire() int {
-	return b.ExpireNow(time.Now())
+	return b.ExpireNow(b.clock.Now())
 }
 
-// Evict items that expire before `now`. O(n*log(n))
+// Evict items that expire before `now`. O(n*log(n)), but the lock
+// is released every ExpireBudget entries.
 func (b *LRUCache) ExpireNow(now time.Time) int {
-	b.lock.Lock()
-	defer b.lock.Unlock()
-
 	i := 0
 	for {
-		e := b.expiredEntry(now)
-		if e == nil {
-			break
+		n := b.expireSome(now, b.expireBudget)
+		i += n
+		if n < b.expireBudget {
+			return i
 		}
-		b.removeEntry(e)
-		i += 1
 	}
-	return i
 }
 
 // Number of entries used in the LRU
 func (b *LRUCache) Len() int {
 	// yes. this stupid thing requires locking
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 
-	return b.lruList.Len()
+	return b.usedLen()
 }
 
-// Get the total capacity of the LRU
+// Get the total capacity of the LRU. For Pool members that's the
+// number of entries currently used.
 func (b *LRUCache) Capacity() int {
 	// yes. this stupid thing requires locking
-	b.lock.Lock()
-	defer b.lock.Unlock()
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.usedLen() + b.freeList.Len()
+}
+
+// Sum of sizes of all the entries in the LRU
+func (b *LRUCache) Size() int64 {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.size
+}
+
+// Get the size limit of the LRU, zero if not set
+func (b *LRUCache) MaxSize() int64 {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 
-	return b.lruList.Len() + b.freeList.Len()
+	return b.maxSize
 }
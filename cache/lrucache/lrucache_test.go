@@ -269,6 +269,115 @@ func TestExtra(t *testing.T) {
 	}
 }
 
+type sized int
+
+func (s sized) Size() int {
+	return int(s)
+}
+
+func TestMaxSize(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCacheOptions(10, Options{MaxSize: 10})
+
+	b.SetSize("a", "va", 4, time.Time{})
+	b.SetSize("b", "vb", 4, time.Time{})
+	if b.Size() != 8 || b.MaxSize() != 10 {
+		t.Error("Expecting different size")
+	}
+
+	// Evicts "a", the least used one
+	b.Set("c", sized(4), time.Time{})
+	if _, ok := b.Get("a"); ok {
+		t.Error("Expecting miss")
+	}
+	if b.Size() != 8 || b.Len() != 2 {
+		t.Error("Expecting different size")
+	}
+
+	// Expired entries go first
+	b.Get("b")
+	b.SetSize("c", "vc", 4, time.Now().Add(time.Duration(-1*time.Second)))
+	b.SetSize("d", "vd", 6, time.Time{})
+	if _, ok := b.Get("c"); ok {
+		t.Error("Expecting miss")
+	}
+	if v, _ := b.Get("b"); v != "vb" {
+		t.Error("Expecting hit")
+	}
+
+	// Overwrite releases the old size
+	b.SetSize("d", "vd", 2, time.Time{})
+	if b.Size() != 6 {
+		t.Error("Expecting different size")
+	}
+
+	// Too big, never stored, old value dropped
+	b.SetSize("b", "vb", 11, time.Time{})
+	if _, ok := b.Get("b"); ok {
+		t.Error("Expecting miss")
+	}
+	if b.Size() != 2 || b.Len() != 1 {
+		t.Error("Expecting different size")
+	}
+
+	// Slot limit still applies, unsized values cost nothing
+	for i := 0; i < 20; i++ {
+		b.Set(randomString(4), "v", time.Time{})
+	}
+	if b.Len() != 10 || b.Size() != 0 {
+		t.Error("Expecting different length")
+	}
+
+	b.Clear()
+	if b.Size() != 0 {
+		t.Error("Expecting different size")
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	t.Parallel()
+	var b *LRUCache
+	reasons := map[string]EvictReason{}
+	onEvict := func(key string, value interface{}, reason EvictReason) {
+		if value != "v"+key {
+			t.Error("Expecting different value")
+		}
+		reasons[key] = reason
+		// Must not deadlock
+		b.Len()
+	}
+	b = NewLRUCacheOptions(3, Options{OnEvict: onEvict})
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", now.Add(time.Duration(-1*time.Second)))
+	b.Set("c", "vc", time.Time{})
+	b.Set("a", "va", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	b.Set("e", "ve", time.Time{})
+	b.Del("a")
+	b.Clear()
+
+	expected := map[string]EvictReason{
+		"a": EvictDeleted,
+		"b": EvictExpired,
+		"c": EvictLRU,
+		"d": EvictCleared,
+		"e": EvictCleared,
+	}
+	for k, r := range expected {
+		if reasons[k] != r {
+			t.Errorf("Expecting %q to be %v, got %v", k, r, reasons[k])
+		}
+	}
+
+	b.Set("f", "vf", time.Time{})
+	b.Set("f", "vf", time.Time{})
+	if reasons["f"] != EvictReplaced {
+		t.Error("Expecting replaced")
+	}
+}
+
 func randomString(l int) string {
 	bytes := make([]byte, l)
 	for i := 0; i < l; i++ {
@@ -373,7 +482,7 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 	ch := make(chan bool)
 	worker := func() {
 		for i := 0; i < bb.N/cpu; i++ {
-			b.Set(randomString(2), "v", time.Time{})
+			b.SetIfAbsent(randomString(2), "v", time.Time{})
 		}
 		ch <- true
 	}
@@ -384,3 +493,454 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 		_ = <-ch
 	}
 }
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(time.Duration(-1*time.Second)))
+	b.SetSoft("b", "vb", now.Add(time.Duration(-1*time.Second)), time.Time{})
+	b.Get("a")
+	b.Get("b")
+	b.Get("miss")
+	b.GetNotStale("a")
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+
+	s := b.Stats()
+	expected := Stats{
+		Gets:      4,
+		Hits:      2,
+		Misses:    2,
+		StaleHits: 1,
+		Sets:      4,
+		Expired:   1,
+		Evicted:   1,
+	}
+	if s != expected {
+		t.Errorf("Expecting different stats %+v", s)
+	}
+	if s.HitRatio() != 0.5 {
+		t.Error("Expecting different ratio")
+	}
+}
+
+func TestRange(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(time.Duration(3*time.Second)))
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", now.Add(time.Duration(1*time.Second)))
+	b.Set("d", "vd", now.Add(time.Duration(2*time.Second)))
+	b.Get("a")
+
+	keys := ""
+	for _, k := range b.Keys() {
+		keys += k
+	}
+	if keys != "adcb" {
+		t.Errorf("Expecting different keys %q", keys)
+	}
+
+	// Modifying the cache while iterating is fine
+	keys = ""
+	b.Range(func(key string, value interface{}, expire time.Time) bool {
+		keys += key
+		b.Del(key)
+		return key != "c"
+	})
+	if keys != "adc" || b.Len() != 1 {
+		t.Errorf("Expecting different keys %q", keys)
+	}
+
+	b.Set("a", "va", now.Add(time.Duration(3*time.Second)))
+	b.Set("c", "vc", now.Add(time.Duration(1*time.Second)))
+	b.Set("d", "vd", now.Add(time.Duration(2*time.Second)))
+	keys = ""
+	b.RangeExpiry(func(key string, value interface{}, expire time.Time) bool {
+		if value != "v"+key {
+			t.Error("Expecting different value")
+		}
+		keys += key
+		return true
+	})
+	if keys != "cda" {
+		t.Errorf("Expecting different keys %q", keys)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCacheOptions(10, Options{
+		JanitorInterval: time.Millisecond,
+		ExpireBudget:    2,
+	})
+	defer b.Close()
+
+	past := time.Now().Add(time.Duration(-1 * time.Second))
+	for i := 0; i < 5; i++ {
+		b.Set(randomString(4), "v", past)
+	}
+	b.Set("a", "va", time.Time{})
+
+	for i := 0; i < 1000 && b.Len() > 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if b.Len() != 1 {
+		t.Error("Expecting janitor to remove expired entries")
+	}
+
+	b.Close()
+	b.Close()
+
+	// Expire still removes all of them, in batches
+	for i := 0; i < 5; i++ {
+		b.Set(randomString(4), "v", past)
+	}
+	if b.Expire() != 5 || b.Len() != 1 {
+		t.Error("Expecting different length")
+	}
+}
+
+func TestBufferedReads(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCacheOptions(3, Options{BufferedReads: true})
+
+	now := time.Now()
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", now.Add(time.Duration(-1*time.Second)))
+	b.Set("c", "vc", time.Time{})
+
+	if v, _ := b.Get("a"); v != "va" {
+		t.Error("Expecting hit")
+	}
+	if _, ok := b.Get("miss"); ok {
+		t.Error("Expecting miss")
+	}
+	if _, ok := b.GetNotStale("b"); ok {
+		t.Error("Expecting miss")
+	}
+	if b.Len() != 2 {
+		t.Error("Expecting stale entry to be removed")
+	}
+
+	// The hit on "a" is applied before eviction
+	b.Set("d", "vd", time.Time{})
+	b.Set("e", "ve", time.Time{})
+	if _, ok := b.GetQuiet("c"); ok {
+		t.Error("Expecting miss")
+	}
+	if v, _ := b.GetNotStale("a"); v != "va" {
+		t.Error("Expecting hit")
+	}
+
+	s := b.Stats()
+	if s.Gets != 4 || s.Hits != 2 || s.Misses != 2 {
+		t.Errorf("Expecting different stats %+v", s)
+	}
+
+	// Lots of hits overflow the buffers without trouble
+	for i := 0; i < 10000; i++ {
+		b.Get("a")
+	}
+	b.Set("f", "vf", time.Time{})
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("Expecting hit")
+	}
+}
+
+func TestConcurrentBufferedReads(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCacheOptions(100, Options{BufferedReads: true, Policy: PolicyTinyLFU})
+
+	done := make(chan bool)
+	worker := func(set bool) {
+		for i := 0; i < 10000; i++ {
+			if set && i%10 == 0 {
+				b.Set(randomString(2), "value", time.Time{})
+			} else {
+				b.Get(randomString(2))
+			}
+		}
+		done <- true
+	}
+	workers := 4
+	for i := 0; i < workers; i++ {
+		go worker(i == 0)
+	}
+	for i := 0; i < workers; i++ {
+		_ = <-done
+	}
+	if b.Len() != 100 {
+		t.Error("Expecting full cache")
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	p := NewPool(4)
+	evicted := ""
+	onEvict := func(key string, value interface{}, reason EvictReason) {
+		evicted += key
+	}
+	a := NewLRUCacheOptions(0, Options{Pool: p, OnEvict: onEvict})
+	b := NewLRUCacheOptions(0, Options{Pool: p, OnEvict: onEvict})
+
+	a.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", time.Time{})
+	a.Set("d", "vd", time.Time{})
+	if p.Free() != 0 || a.Len() != 2 || b.Len() != 2 {
+		t.Error("Expecting full pool")
+	}
+
+	// "a" is hit, "b" is now the oldest of all
+	a.Get("a")
+	a.Set("e", "ve", time.Time{})
+	if evicted != "b" || a.Len() != 3 || b.Len() != 1 {
+		t.Errorf("Expecting eviction from the other cache %q", evicted)
+	}
+
+	// b can take its last entry back from a
+	a.Set("f", "vf", time.Time{})
+	b.Set("g", "vg", time.Time{})
+	if evicted != "bcd" || a.Len() != 3 || b.Len() != 1 {
+		t.Errorf("Expecting different evictions %q", evicted)
+	}
+
+	// Nothing older in b, a evicts its own entry
+	b.Get("g")
+	a.Set("h", "vh", time.Time{})
+	if evicted != "bcda" || a.Len() != 3 {
+		t.Errorf("Expecting eviction from own entries %q", evicted)
+	}
+
+	// Freed entries go back to the pool
+	a.Del("e")
+	if a.Clear() != 2 || p.Free() != 3 {
+		t.Error("Expecting entries back in the pool")
+	}
+	for i := 0; i < 10; i++ {
+		b.Set(randomString(2), "v", time.Time{})
+	}
+	if b.Len() != 4 || p.Free() != 0 {
+		t.Error("Expecting b to use the whole pool")
+	}
+}
+
+func TestPoolBusy(t *testing.T) {
+	t.Parallel()
+	p := NewPool(2)
+	a := NewLRUCacheOptions(0, Options{Pool: p})
+	b := NewLRUCacheOptions(0, Options{Pool: p})
+
+	a.Set("a", "va", time.Time{})
+	a.Set("b", "vb", time.Time{})
+
+	// b has nothing to evict and a is busy, b doesn't wait for it
+	a.lock.Lock()
+	stored := b.SetIfAbsent("c", "vc", time.Time{})
+	a.lock.Unlock()
+	if stored || b.Len() != 0 {
+		t.Error("Expecting item not to be stored")
+	}
+	if !b.SetIfAbsent("c", "vc", time.Time{}) {
+		t.Error("Expecting item to be stored")
+	}
+	if v, _ := b.Get("c"); v != "vc" || a.Len() != 1 {
+		t.Error("Expecting entry taken from a")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(time.Duration(1*time.Second)))
+	b.Set("b", "vb", now.Add(time.Duration(2*time.Second)))
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", now.Add(time.Duration(-1*time.Second)))
+
+	if b.Touch("d", now.Add(time.Hour)) {
+		t.Error("Expecting stale key not to be touched")
+	}
+	if b.Touch("x", now.Add(time.Hour)) {
+		t.Error("Expecting missing key not to be touched")
+	}
+
+	if !b.Touch("a", now.Add(time.Duration(3*time.Second))) {
+		t.Error("Expecting touch")
+	}
+	if !b.Touch("b", time.Time{}) || !b.Touch("c", now.Add(time.Duration(4*time.Second))) {
+		t.Error("Expecting touch")
+	}
+
+	if b.ExpireNow(now.Add(time.Duration(3500*time.Millisecond))) != 1 {
+		t.Error("Expecting one expired")
+	}
+	if _, ok := b.GetQuiet("a"); ok {
+		t.Error("Expecting miss")
+	}
+	if b.ExpireNow(now.Add(time.Hour)) != 1 || b.Len() != 1 {
+		t.Error("Expecting only b to stay")
+	}
+	if _, ok := b.GetQuiet("b"); !ok {
+		t.Error("Expecting hit")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	for _, buffered := range []bool{false, true} {
+		b := NewLRUCacheOptions(3, Options{
+			IdleTimeout:   time.Duration(10 * time.Second),
+			BufferedReads: buffered,
+		})
+
+		now := time.Now()
+		b.Set("a", "va", now.Add(time.Duration(1*time.Second)))
+		b.Set("b", "vb", now.Add(time.Duration(1*time.Second)))
+		b.Set("c", "vc", time.Time{})
+
+		// Keep reading a, while b goes stale
+		for i := 0; i < 5; i++ {
+			if _, ok := b.GetNotStaleNow("a", now); !ok {
+				t.Error("Expecting hit")
+			}
+			now = now.Add(time.Duration(5 * time.Second))
+		}
+		if _, ok := b.GetNotStaleNow("b", now); ok {
+			t.Error("Expecting miss")
+		}
+		if _, ok := b.GetNotStaleNow("c", now); !ok {
+			t.Error("Expecting hit")
+		}
+		if b.ExpireNow(now.Add(time.Duration(4*time.Second))) != 0 {
+			t.Error("Expecting no expiry")
+		}
+		if b.ExpireNow(now.Add(time.Duration(6*time.Second))) != 1 {
+			t.Error("Expecting expiry")
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", time.Time{})
+	b.Resize(5)
+	if b.Capacity() != 5 || b.Len() != 2 {
+		t.Error("Expecting bigger cache")
+	}
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	b.Set("e", "ve", time.Time{})
+	if b.Len() != 5 {
+		t.Error("Expecting full cache")
+	}
+
+	// Least used go first
+	b.Get("a")
+	b.Resize(2)
+	if b.Capacity() != 2 || b.Len() != 2 {
+		t.Error("Expecting smaller cache")
+	}
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("Expecting hit")
+	}
+	if _, ok := b.GetQuiet("e"); !ok {
+		t.Error("Expecting hit")
+	}
+
+	// Free entries are dropped before used ones
+	b.Resize(4)
+	b.Resize(2)
+	if b.Len() != 2 {
+		t.Error("Expecting no eviction")
+	}
+
+	b.Resize(0)
+	b.Set("f", "vf", time.Time{})
+	if b.Len() != 0 {
+		t.Error("Expecting empty cache")
+	}
+
+	c := NewLRUCacheOptions(100, Options{Policy: PolicyTinyLFU})
+	for i := 0; i < 200; i++ {
+		c.Set(randomString(3), "v", time.Time{})
+	}
+	c.Resize(10)
+	c.Resize(1000)
+	for i := 0; i < 2000; i++ {
+		c.Set(randomString(3), "v", time.Time{})
+	}
+	if c.Len() != 1000 {
+		t.Error("Expecting full cache")
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	t.Parallel()
+	p := NewPool(2)
+	a := NewLRUCacheOptions(0, Options{Pool: p})
+	b := NewLRUCacheOptions(0, Options{Pool: p})
+
+	a.Set("a", "va", time.Time{})
+	p.Resize(4)
+	b.Set("b", "vb", time.Time{})
+	a.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+	if p.Free() != 0 || a.Len()+b.Len() != 4 {
+		t.Error("Expecting full pool")
+	}
+
+	// Globally oldest go first
+	p.Resize(1)
+	if p.Capacity() != 1 || a.Len() != 0 || b.Len() != 1 {
+		t.Error("Expecting smaller pool")
+	}
+	a.Set("e", "ve", time.Time{})
+	if a.Len()+b.Len() != 1 {
+		t.Error("Expecting one entry")
+	}
+}
+
+var parallelKeys = func() []string {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = randomString(2)
+	}
+	return keys
+}()
+
+func benchmarkParallelGet(bb *testing.B, o Options) {
+	b := NewLRUCacheOptions(1000, o)
+	for i := 0; i < 1000; i++ {
+		b.Set(randomString(2), "value", time.Time{})
+	}
+
+	bb.ResetTimer()
+	bb.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(parallelKeys))
+		for pb.Next() {
+			b.Get(parallelKeys[i%len(parallelKeys)])
+			i += 1
+		}
+	})
+}
+
+// Compare with -cpu=1,2,4,8: the buffered one should scale.
+func BenchmarkParallelGet(bb *testing.B) {
+	benchmarkParallelGet(bb, Options{})
+}
+
+func BenchmarkParallelGetBuffered(bb *testing.B) {
+	benchmarkParallelGet(bb, Options{BufferedReads: true})
+}
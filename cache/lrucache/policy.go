@@ -0,0 +1,260 @@
+package lrucache
+
+import (
+	"hash/maphash"
+)
+
+// Decides which entry is evicted when the cache is full.
+type Policy int
+
+const (
+	// Plain LRU. Evicts the least recently used entry.
+	PolicyLRU Policy = iota
+
+	// Segmented LRU, similar to 2Q. New entries land in a
+	// probation segment and move to a protected one on a second
+	// hit. Eviction starts with probation, so a one-off scan of
+	// many keys can't flush the protected entries.
+	PolicySLRU
+
+	// W-TinyLFU. New entries land in a small LRU window. Entries
+	// leaving the window compete with the SLRU victim, the one
+	// accessed more often according to a count-min sketch stays.
+	PolicyTinyLFU
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyLRU:
+		return "lru"
+	case PolicySLRU:
+		return "slru"
+	case PolicyTinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+// With PolicySLRU and PolicyTinyLFU used entries are spread over
+// three lists:
+//
+//  - `window`: recently added entries, TinyLFU only.
+//  - `lruList`: probation segment. All the entries with PolicyLRU.
+//  - `protected`: entries hit at least twice.
+
+// Set up the segments for a given capacity.
+func (b *LRUCache) initPolicy(p Policy, capacity uint) {
+	b.policy = p
+	b.window.Init()
+	b.protected.Init()
+	b.sketch = nil
+	b.resizePolicy(capacity)
+}
+
+// Adjust the segments to a new capacity, keeping the entries.
+func (b *LRUCache) resizePolicy(capacity uint) {
+	b.windowCap = 0
+	main := int(capacity)
+	if b.policy == PolicyTinyLFU {
+		// 1% for the window, as in the W-TinyLFU paper.
+		b.windowCap = main / 100
+		if b.windowCap < 1 {
+			b.windowCap = 1
+		}
+		main -= b.windowCap
+		if b.sketch == nil || b.sketch.width() < capacity {
+			b.sketch = newSketch(capacity)
+		}
+	}
+	b.protectedCap = main * 8 / 10
+	for b.protected.Len() > b.protectedCap {
+		demoted := b.protected.Back()
+		b.protected.Remove(demoted)
+		b.lruList.PushElementFront(demoted)
+	}
+}
+
+// Number of used entries.
+func (b *LRUCache) usedLen() int {
+	return b.lruList.Len() + b.protected.Len() + b.window.Len()
+}
+
+// Is the entry linked to one of the used lists?
+func (b *LRUCache) isUsed(e *entry) bool {
+	l := e.element.list
+	return l == &b.lruList || l == &b.protected || l == &b.window
+}
+
+// Used lists, most valuable entries first.
+func (b *LRUCache) usedLists() [3]*List {
+	return [3]*List{&b.protected, &b.window, &b.lruList}
+}
+
+// Link a fresh entry to the right used list.
+func (b *LRUCache) pushUsed(e *entry) {
+	if b.sketch != nil {
+		b.sketch.add(e.key)
+	}
+	if b.policy != PolicyTinyLFU {
+		b.lruList.PushElementFront(&e.element)
+		return
+	}
+
+	b.window.PushElementFront(&e.element)
+	if b.window.Len() > b.windowCap && b.hasFree() {
+		// Still room in the cache, no need to compete for it.
+		el := b.window.Back()
+		b.window.Remove(el)
+		b.lruList.PushElementFront(el)
+	}
+}
+
+// Are there entries left that can be used without evicting?
+func (b *LRUCache) hasFree() bool {
+	return b.freeList.Len() > 0 || (b.pool != nil && b.pool.Free() > 0)
+}
+
+// Record a hit on the entry.
+func (b *LRUCache) touchEntry(e *entry) {
+	if b.sketch != nil {
+		b.sketch.add(e.key)
+	}
+	if b.pool != nil {
+		e.touched = b.pool.now()
+	}
+
+	l := e.element.list
+	if b.policy != PolicyLRU && l == &b.lruList {
+		// Second hit, promote from probation.
+		l.Remove(&e.element)
+		b.protected.PushElementFront(&e.element)
+		if b.protected.Len() > b.protectedCap {
+			demoted := b.protected.Back()
+			b.protected.Remove(demoted)
+			b.lruList.PushElementFront(demoted)
+		}
+		return
+	}
+	l.Remove(&e.element)
+	l.PushElementFront(&e.element)
+}
+
+// Give me the last entry of the first non empty segment, probation
+// first. Panics if the cache is empty.
+func (b *LRUCache) backEntry() *entry {
+	for _, l := range [3]*List{&b.lruList, &b.window, &b.protected} {
+		if l.Len() > 0 {
+			return l.Back().Value.(*entry)
+		}
+	}
+	panic("empty cache")
+}
+
+// Give me the least loved entry, without changing anything. Must not
+// be called on empty cache.
+func (b *LRUCache) leastUsedEntry() *entry {
+	if b.policy != PolicyTinyLFU {
+		return b.backEntry()
+	}
+
+	if b.lruList.Len()+b.protected.Len() == 0 {
+		return b.window.Back().Value.(*entry)
+	}
+
+	var victim *entry
+	if b.lruList.Len() > 0 {
+		victim = b.lruList.Back().Value.(*entry)
+	} else {
+		victim = b.protected.Back().Value.(*entry)
+	}
+	if b.window.Len() <= b.windowCap {
+		return victim
+	}
+
+	// The window is over its share. Its last entry either gets
+	// admitted to probation once the victim is gone, see
+	// admitWindow, or is evicted itself.
+	candidate := b.window.Back().Value.(*entry)
+	if b.sketch.estimate(candidate.key) <= b.sketch.estimate(victim.key) {
+		return candidate
+	}
+	return victim
+}
+
+// Called after evicting an entry from probation or protected. If
+// the window is over its share, its last entry takes the free spot.
+func (b *LRUCache) admitWindow() {
+	if b.policy != PolicyTinyLFU || b.window.Len() <= b.windowCap {
+		return
+	}
+	el := b.window.Back()
+	b.window.Remove(el)
+	b.lruList.PushElementFront(el)
+}
+
+// Count-min sketch with 4 bit counters, estimating how often keys
+// were accessed recently. Counters are halved every 10*width
+// additions so that old popularity fades away.
+type sketch struct {
+	seed    maphash.Seed
+	rows    [4][]uint8
+	mask    uint32
+	added   int
+	resetAt int
+}
+
+func newSketch(capacity uint) *sketch {
+	width := uint32(16)
+	for width < uint32(capacity) && width < 1<<30 {
+		width <<= 1
+	}
+	s := &sketch{
+		seed:    maphash.MakeSeed(),
+		mask:    width - 1,
+		resetAt: 10 * int(width),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) width() uint {
+	return uint(s.mask) + 1
+}
+
+func (s *sketch) indexes(key string) (uint32, uint32) {
+	h := maphash.String(s.seed, key)
+	return uint32(h), uint32(h>>32) | 1
+}
+
+func (s *sketch) add(key string) {
+	h1, h2 := s.indexes(key)
+	for i := range s.rows {
+		c := &s.rows[i][(h1+uint32(i)*h2)&s.mask]
+		if *c < 15 {
+			*c += 1
+		}
+	}
+
+	s.added += 1
+	if s.added >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.added /= 2
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	h1, h2 := s.indexes(key)
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][(h1+uint32(i)*h2)&s.mask]; c < min {
+			min = c
+		}
+	}
+	return min
+}
@@ -0,0 +1,113 @@
+package lrucache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var policies = []Policy{PolicyLRU, PolicySLRU, PolicyTinyLFU}
+
+func TestPolicyBasic(t *testing.T) {
+	t.Parallel()
+	for _, p := range policies {
+		b := NewLRUCacheOptions(3, Options{Policy: p})
+		now := time.Now()
+
+		b.Set("a", "va", time.Time{})
+		b.Set("b", "vb", now.Add(time.Duration(-1*time.Second)))
+		b.Set("c", "vc", time.Time{})
+		if v, _ := b.Get("a"); v != "va" {
+			t.Errorf("%v: Expecting hit", p)
+		}
+
+		// Expired entries go first
+		b.Set("d", "vd", time.Time{})
+		if _, ok := b.GetQuiet("b"); ok {
+			t.Errorf("%v: Expecting miss", p)
+		}
+		if _, ok := b.GetNotStale("a"); !ok {
+			t.Errorf("%v: Expecting hit", p)
+		}
+
+		// Cache never goes over capacity
+		for i := 0; i < 10; i++ {
+			b.Set(fmt.Sprint(i), i, time.Time{})
+			b.Get(fmt.Sprint(i))
+		}
+		if b.Len() != 3 || b.Capacity() != 3 || len(b.Keys()) != 3 {
+			t.Errorf("%v: Expecting different length", p)
+		}
+
+		if v, ok := b.Del("9"); v != 9 || !ok {
+			t.Errorf("%v: Expecting hit", p)
+		}
+		b.Set("e", "ve", now.Add(time.Duration(-1*time.Second)))
+		if b.Expire() != 1 {
+			t.Errorf("%v: Expecting different expire", p)
+		}
+		if b.Clear() != 2 || b.Len() != 0 {
+			t.Errorf("%v: Expecting different length", p)
+		}
+	}
+}
+
+// Hits on a warmed up hot set after a long scan of keys seen only
+// once.
+func scanHits(p Policy) int {
+	b := NewLRUCacheOptions(100, Options{Policy: p})
+	for i := 0; i < 500; i++ {
+		hot := fmt.Sprint("hot", i%50)
+		if _, ok := b.Get(hot); !ok {
+			b.Set(hot, i, time.Time{})
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		b.Set(fmt.Sprint("scan", i), i, time.Time{})
+	}
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, ok := b.Get(fmt.Sprint("hot", i)); ok {
+			hits += 1
+		}
+	}
+	return hits
+}
+
+func TestPolicyScan(t *testing.T) {
+	t.Parallel()
+	if h := scanHits(PolicyLRU); h != 0 {
+		t.Errorf("Expecting lru to be flushed, %v hits", h)
+	}
+	for _, p := range []Policy{PolicySLRU, PolicyTinyLFU} {
+		if h := scanHits(p); h < 40 {
+			t.Errorf("%v: Expecting scan resistance, %v hits", p, h)
+		}
+	}
+}
+
+func TestPolicyPoolAdmission(t *testing.T) {
+	t.Parallel()
+	p := NewPool(4)
+	o := NewLRUCacheOptions(0, Options{Pool: p})
+	b := NewLRUCacheOptions(0, Options{Pool: p, Policy: PolicyTinyLFU})
+
+	o.Set("o", "vo", time.Time{})
+	b.Set("p", "vp", time.Time{})
+	b.Set("q", "vq", time.Time{})
+	b.Set("r", "vr", time.Time{})
+	for i := 0; i < 3; i++ {
+		b.Get("q")
+	}
+	b.Get("r")
+
+	// q beats p, but the older entry of o goes instead of p, so q
+	// stays in the window
+	b.Set("s", "vs", time.Time{})
+	if o.Len() != 0 || b.Len() != 4 {
+		t.Error("Expecting entry taken from o")
+	}
+	if b.window.Len() != 3 || b.lruList.Len() != 1 {
+		t.Errorf("Not expecting admission, window %d", b.window.Len())
+	}
+}
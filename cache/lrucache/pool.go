@@ -0,0 +1,137 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Entries shared by a group of caches, see Options.Pool. The caches
+// together never hold more entries than the pool capacity, but any
+// single one of them can grow to use all of it.
+//
+// A member cache in need of an entry takes a free one from the pool.
+// If there are none, it evicts the least recently used entry among
+// its own and the ones from other members. Other members are only
+// considered if their lock is available right away, so members never
+// wait for each other.
+type Pool struct {
+	lock     sync.Mutex
+	free     List        // entries not used by any member
+	members  []*LRUCache // caches sharing the entries
+	capacity int         // total number of entries
+	excess   int         // entries to drop when given back, after Resize
+	clock    Clock       // of the first member, for entry access times
+	start    time.Time   // base for entry access times
+}
+
+// Create a pool of entries. Allocate all the needed memory. O(capacity)
+func NewPool(capacity uint) *Pool {
+	p := &Pool{
+		capacity: int(capacity),
+	}
+	p.free.Init()
+	allocEntries(capacity, &p.free)
+	return p
+}
+
+// Total number of entries in the pool.
+func (p *Pool) Capacity() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.capacity
+}
+
+// Number of entries not used by any member.
+func (p *Pool) Free() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.free.Len()
+}
+
+func (p *Pool) join(b *LRUCache) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.clock == nil {
+		// Set once, before any member can use it.
+		p.clock = b.clock
+		p.start = p.clock.Now()
+	}
+	p.members = append(p.members, b)
+}
+
+// Time used to compare entries across members, from the Clock of
+// the first member.
+func (p *Pool) now() int64 {
+	return int64(p.clock.Now().Sub(p.start))
+}
+
+// Move a free entry from the pool to the list, if there is one.
+func (p *Pool) get(l *List) *entry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.free.Len() == 0 {
+		return nil
+	}
+	el := p.free.PopElementFront()
+	l.PushElementFront(el)
+	return el.Value.(*entry)
+}
+
+// Give back all the entries from the list.
+func (p *Pool) put(l *List) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for l.Len() > 0 {
+		el := l.PopElementFront()
+		if p.excess > 0 {
+			p.excess -= 1
+			continue
+		}
+		p.free.PushElementFront(el)
+	}
+}
+
+// Find an entry used less recently than `own` in other members,
+// evict it and move it to the free list of `b`. Members that are
+// locked are skipped. Must be called with the lock of `b` held.
+func (p *Pool) steal(b *LRUCache, own *entry) (victim *entry) {
+	p.lock.Lock()
+	members := p.members
+	p.lock.Unlock()
+
+	var best *LRUCache
+	for _, m := range members {
+		if m == b || !m.lock.TryLock() {
+			continue
+		}
+		if m.usedLen() > 0 {
+			e := m.backEntry()
+			if (own == nil || e.touched < own.touched) &&
+				(victim == nil || e.touched < victim.touched) {
+				if best != nil {
+					best.lock.Unlock()
+				}
+				best, victim = m, e
+				continue
+			}
+		}
+		m.lock.Unlock()
+	}
+	if best == nil {
+		return nil
+	}
+
+	best.removeEntry(victim, EvictLRU)
+	best.freeList.Remove(&victim.element)
+	b.freeList.PushElementFront(&victim.element)
+	// OnEvict runs once the lock of `b` is released.
+	b.evicted = append(b.evicted, best.evicted...)
+	best.evicted = nil
+	best.lock.Unlock()
+	return victim
+}
@@ -0,0 +1,69 @@
+package lrucache
+
+import (
+	"sort"
+	"time"
+)
+
+// Iteration works on a copy of the entries taken under the lock. The
+// callbacks run without the lock held, so they may freely use the
+// cache, and see the cache as it was when the iteration started.
+
+// Keys of all the entries, most recently used first. O(n)
+func (b *LRUCache) Keys() []string {
+	b.writeLock()
+	defer b.unlock()
+
+	keys := make([]string, 0, b.usedLen())
+	for _, l := range b.usedLists() {
+		for el := l.Front(); el != nil; el = el.Next() {
+			keys = append(keys, el.Value.(*entry).key)
+		}
+	}
+	return keys
+}
+
+// Call fn for every entry, most recently used first, until it
+// returns false. Doesn't modify LRU scores. O(n)
+func (b *LRUCache) Range(fn func(key string, value interface{}, expire time.Time) bool) {
+	b.writeLock()
+	items := make([]Item, 0, b.usedLen())
+	for _, l := range b.usedLists() {
+		for el := l.Front(); el != nil; el = el.Next() {
+			items = append(items, el.Value.(*entry).item())
+		}
+	}
+	b.unlock()
+
+	for _, it := range items {
+		if !fn(it.Key, it.Value, it.Expire) {
+			return
+		}
+	}
+}
+
+// Call fn for every entry that has expiry set, soonest to expire
+// first, until it returns false. Entries without expiry are
+// skipped. O(n*log(n))
+func (b *LRUCache) RangeExpiry(fn func(key string, value interface{}, expire time.Time) bool) {
+	b.writeLock()
+	items := make([]Item, 0, len(b.priorityQueue))
+	for _, e := range b.priorityQueue {
+		items = append(items, e.item())
+	}
+	b.unlock()
+
+	SortByExpiry(items)
+	for _, it := range items {
+		if !fn(it.Key, it.Value, it.Expire) {
+			return
+		}
+	}
+}
+
+// Sort items by expiry time, soonest first.
+func SortByExpiry(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Expire.Before(items[j].Expire)
+	})
+}
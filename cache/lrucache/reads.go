@@ -0,0 +1,136 @@
+package lrucache
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// With BufferedReads a hit doesn't touch the LRU lists right away.
+// Instead the entry is recorded in one of the striped read buffers,
+// while holding only the read lock. The buffers are drained, and
+// the LRU order updated, whenever somebody takes the write lock, or
+// when a buffer fills up. Same idea as in Caffeine.
+//
+// Recording is lossy: when a buffer is full the hit is dropped. By
+// the time a buffer is drained the recorded entry may have been
+// reused for another key, in which case the wrong key gets its LRU
+// score bumped. Both only make the LRU order a bit less accurate.
+
+const (
+	readBufferSize = 16
+	readBufferMask = readBufferSize - 1
+)
+
+type readBuffer struct {
+	head   atomic.Uint32 // next slot to be written
+	tail   atomic.Uint32 // next slot to be drained, written under the lock
+	slots  [readBufferSize]atomic.Pointer[entry]
+	hits   atomic.Uint64 // stats, to avoid sharing a counter
+	misses atomic.Uint64 //
+	_      [64]byte      // keep stripes in separate cache lines
+}
+
+// Remember a hit on the entry. False if the buffer is full.
+func (r *readBuffer) record(e *entry) bool {
+	h := r.head.Add(1) - 1
+	if h-r.tail.Load() >= readBufferSize {
+		return false
+	}
+	r.slots[h&readBufferMask].Store(e)
+	return true
+}
+
+// Allocate read buffers, a couple per CPU.
+func (b *LRUCache) initReads(enabled bool) {
+	b.reads = nil
+	if !enabled {
+		return
+	}
+	n := 4
+	for n < 2*runtime.GOMAXPROCS(0) && n < 64 {
+		n <<= 1
+	}
+	b.reads = make([]readBuffer, n)
+	b.readMask = uint32(n - 1)
+}
+
+// Pick a random stripe. Cheaper than anything shared.
+func (b *LRUCache) readStripe() *readBuffer {
+	return &b.reads[rand.Uint32()&b.readMask]
+}
+
+// Take the write lock, applying the buffered hits.
+func (b *LRUCache) writeLock() {
+	b.lock.Lock()
+	b.drainReads()
+}
+
+// Apply the buffered hits to the LRU order. Must be called with the
+// write lock held.
+func (b *LRUCache) drainReads() {
+	for i := range b.reads {
+		r := &b.reads[i]
+		head, tail := r.head.Load(), r.tail.Load()
+		n := head - tail
+		if n > readBufferSize {
+			n = readBufferSize
+		}
+		for j := uint32(0); j < n; j++ {
+			e := r.slots[(tail+j)&readBufferMask].Swap(nil)
+			if e != nil && b.isUsed(e) {
+				b.touchEntry(e)
+			}
+		}
+		r.tail.Store(head)
+	}
+}
+
+// Add counters kept in the read buffers.
+func (b *LRUCache) readStats(s *Stats) {
+	for i := range b.reads {
+		hits, misses := b.reads[i].hits.Load(), b.reads[i].misses.Load()
+		s.Gets += hits + misses
+		s.Hits += hits
+		s.Misses += misses
+	}
+}
+
+// Lookup holding only the read lock. Returns done=false if the write
+// lock is needed after all: to remove a stale entry, to refresh one
+// or to extend its expiry. Misses are left for the caller to count.
+func (b *LRUCache) getShared(key string, notStale bool, now time.Time) (value interface{}, ok, done bool) {
+	b.lock.RLock()
+	e := b.table[key]
+	if e == nil {
+		b.lock.RUnlock()
+		return nil, false, true
+	}
+
+	if notStale && b.idleTimeout > 0 && !e.expire.IsZero() {
+		b.lock.RUnlock()
+		return nil, false, false
+	}
+	if !e.soft.IsZero() || (notStale && !e.expire.IsZero()) {
+		if now.IsZero() {
+			now = b.clock.Now()
+		}
+		if (notStale && e.expired(now)) || (!e.soft.IsZero() && e.soft.Before(now)) {
+			b.lock.RUnlock()
+			return nil, false, false
+		}
+	}
+
+	value = e.value
+	r := b.readStripe()
+	recorded := r.record(e)
+	b.lock.RUnlock()
+
+	r.hits.Add(1)
+	if !recorded && b.lock.TryLock() {
+		b.drainReads()
+		b.unlock()
+	}
+	return value, true, true
+}
@@ -0,0 +1,86 @@
+package lrucache
+
+// Change the number of entries. Growing allocates another block of
+// entries, shrinking drops free entries first and then evicts the
+// least used ones, as if the cache was full. Memory of a block is
+// released only once all of its entries are dropped. Pool members
+// can't be resized, resize the Pool instead. O(abs(difference))
+func (b *LRUCache) Resize(capacity uint) {
+	if b.pool != nil {
+		panic("lrucache: Resize of a Pool member")
+	}
+	b.writeLock()
+	defer b.unlock()
+
+	b.resizePolicy(capacity)
+	n := int(capacity) - (b.usedLen() + b.freeList.Len())
+	if n > 0 {
+		allocEntries(uint(n), &b.freeList)
+	}
+	now := b.clock.Now()
+	for ; n < 0; n++ {
+		if b.freeList.Len() == 0 {
+			b.removeEntry(b.victimEntry(now))
+		}
+		b.freeList.PopElementFront()
+	}
+}
+
+// Change the total number of entries. Growing allocates another
+// block of entries. Shrinking drops free entries first, then evicts
+// the least recently used entries of all the members.
+func (p *Pool) Resize(capacity uint) {
+	p.lock.Lock()
+	n := int(capacity) - p.capacity
+	p.capacity = int(capacity)
+	if n > 0 {
+		// Cancel pending drops first.
+		c := min(n, p.excess)
+		p.excess -= c
+		allocEntries(uint(n-c), &p.free)
+	} else {
+		p.excess -= n
+	}
+	for p.excess > 0 && p.free.Len() > 0 {
+		p.free.PopElementFront()
+		p.excess -= 1
+	}
+	members := p.members
+	p.lock.Unlock()
+
+	for _, m := range members {
+		m.writeLock()
+		m.resizePolicy(capacity)
+		m.unlock()
+	}
+
+	// Entries given back by the members are dropped in put().
+	for p.pendingDrops() > 0 {
+		var oldest *LRUCache
+		var touched int64
+		for _, m := range members {
+			m.writeLock()
+			if m.usedLen() > 0 {
+				if e := m.backEntry(); oldest == nil || e.touched < touched {
+					oldest, touched = m, e.touched
+				}
+			}
+			m.unlock()
+		}
+		if oldest == nil {
+			break
+		}
+		oldest.writeLock()
+		if oldest.usedLen() > 0 {
+			oldest.removeEntry(oldest.victimEntry(oldest.clock.Now()))
+		}
+		oldest.unlock()
+	}
+}
+
+func (p *Pool) pendingDrops() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.excess
+}
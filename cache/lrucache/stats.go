@@ -0,0 +1,42 @@
+package lrucache
+
+// Counters describing how the cache is doing. All of them only ever
+// grow.
+type Stats struct {
+	Gets      uint64 // lookups with Get, GetNotStale and GetOrLoad
+	Hits      uint64 // lookups that found the key
+	Misses    uint64 // lookups that didn't
+	StaleHits uint64 // hits served past the soft expiry
+	Sets      uint64 // items added, including loaded ones
+	Expired   uint64 // entries removed because they expired
+	Evicted   uint64 // entries pushed out to make room
+}
+
+// Add counters from another Stats.
+func (s *Stats) Add(o Stats) {
+	s.Gets += o.Gets
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.StaleHits += o.StaleHits
+	s.Sets += o.Sets
+	s.Expired += o.Expired
+	s.Evicted += o.Evicted
+}
+
+// Ratio of hits to lookups, zero if there were no lookups.
+func (s Stats) HitRatio() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// Get a snapshot of the counters. O(1)
+func (b *LRUCache) Stats() Stats {
+	b.writeLock()
+	defer b.unlock()
+
+	s := b.stats
+	b.readStats(&s)
+	return s
+}
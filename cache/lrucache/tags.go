@@ -0,0 +1,104 @@
+package lrucache
+
+import (
+	"strings"
+	"time"
+)
+
+// Entries with a given tag, by tag.
+type tagIndex map[string]map[*entry]struct{}
+
+// Add an item to the cache overwriting existing one if it exists,
+// labelled with tags for InvalidateTag. Tags are dropped when the
+// item is overwritten with Set, and aren't kept by Dump. CompareAndSwap,
+// Update and loads keep them. O(log(n)) if expiry
+// is set, O(1) when clear.
+func (b *LRUCache) SetTags(key string, value interface{}, expire time.Time, tags ...string) {
+	b.writeLock()
+	defer b.unlock()
+
+	b.setNow(key, value, sizeOf(value), time.Time{}, expire, time.Time{})
+	b.retag(key, tags)
+}
+
+// Tags of the entry of a key, nil if there is none.
+func (b *LRUCache) tagsOf(key string) []string {
+	if e := b.table[key]; e != nil {
+		return e.tags
+	}
+	return nil
+}
+
+// Tag the entry of a key again after its value was replaced, if it's
+// still there.
+func (b *LRUCache) retag(key string, tags []string) {
+	if e := b.table[key]; e != nil && len(tags) > 0 {
+		b.tagEntry(e, tags)
+	}
+}
+
+func (b *LRUCache) tagEntry(e *entry, tags []string) {
+	e.tags = append([]string(nil), tags...)
+	for _, tag := range e.tags {
+		set := b.tags[tag]
+		if set == nil {
+			set = make(map[*entry]struct{})
+			b.tags[tag] = set
+		}
+		set[e] = struct{}{}
+	}
+}
+
+func (b *LRUCache) untagEntry(e *entry) {
+	for _, tag := range e.tags {
+		set := b.tags[tag]
+		delete(set, e)
+		if len(set) == 0 {
+			delete(b.tags, tag)
+		}
+	}
+	e.tags = nil
+}
+
+// Remove all the entries with a given tag. Returns the number of
+// removed entries. O(number of tagged entries * log(n))
+func (b *LRUCache) InvalidateTag(tag string) int {
+	b.writeLock()
+	defer b.unlock()
+
+	set := b.tags[tag]
+	entries := make([]*entry, 0, len(set))
+	for e := range set {
+		entries = append(entries, e)
+	}
+	for _, e := range entries {
+		b.removeEntry(e, EvictDeleted)
+	}
+	return len(entries)
+}
+
+// Remove all the entries with keys starting with a prefix, including
+// remembered loader errors. Returns the number of removed entries.
+// O(n)
+func (b *LRUCache) InvalidatePrefix(prefix string) int {
+	b.writeLock()
+	defer b.unlock()
+
+	if b.negative != nil {
+		b.negative.InvalidatePrefix(prefix)
+	}
+	for key := range b.loads {
+		if strings.HasPrefix(key, prefix) {
+			b.dropLoad(key)
+		}
+	}
+
+	var removed int
+	for key, e := range b.table {
+		if strings.HasPrefix(key, prefix) {
+			b.removeEntry(e, EvictDeleted)
+			removed += 1
+		}
+	}
+	return removed
+}
@@ -0,0 +1,114 @@
+package lrucache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	t.Parallel()
+	evicted := 0
+	b := NewLRUCacheOptions(5, Options{
+		OnEvict: func(key string, value interface{}, reason EvictReason) {
+			if reason == EvictDeleted {
+				evicted += 1
+			}
+		},
+	})
+
+	b.SetTags("u1:a", "va", time.Time{}, "user:1")
+	b.SetTags("u1:b", "vb", time.Time{}, "user:1", "shared")
+	b.SetTags("u2:a", "vc", time.Time{}, "user:2", "shared")
+	b.Set("d", "vd", time.Time{})
+
+	if b.InvalidateTag("missing") != 0 {
+		t.Error("Expecting nothing removed")
+	}
+	if b.InvalidateTag("shared") != 2 || b.Len() != 2 || evicted != 2 {
+		t.Error("Expecting two removed")
+	}
+	if b.InvalidateTag("user:1") != 1 || b.Len() != 1 {
+		t.Error("Expecting one removed")
+	}
+	if len(b.tags) != 0 {
+		t.Error("Expecting empty tag index")
+	}
+
+	// Overwriting drops the tags
+	b.SetTags("e", "ve", time.Time{}, "t")
+	b.Set("e", "ve2", time.Time{})
+	if b.InvalidateTag("t") != 0 || b.Len() != 2 {
+		t.Error("Expecting tags to be dropped")
+	}
+
+	// So does eviction, entries are reused
+	b.SetTags("f", "vf", time.Time{}, "t")
+	for i := 0; i < 10; i++ {
+		b.Set(randomString(3), "v", time.Time{})
+	}
+	if b.InvalidateTag("t") != 0 || b.Len() != 5 {
+		t.Error("Expecting no tagged entries")
+	}
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(5)
+
+	b.Set("user:1:a", "va", time.Time{})
+	b.Set("user:1:b", "vb", time.Time{})
+	b.Set("user:12:a", "vc", time.Time{})
+	b.Set("group:1", "vd", time.Time{})
+
+	if b.InvalidatePrefix("user:1:") != 2 || b.Len() != 2 {
+		t.Error("Expecting two removed")
+	}
+	if b.InvalidatePrefix("") != 2 || b.Len() != 0 {
+		t.Error("Expecting all removed")
+	}
+}
+
+func TestTagsKept(t *testing.T) {
+	t.Parallel()
+
+	refresh := func(key string) (interface{}, time.Time, time.Time, error) {
+		return "refreshed", time.Time{}, time.Time{}, nil
+	}
+	b := NewLRUCacheOptions(5, Options{Refresh: refresh})
+
+	b.SetTags("a", "va", time.Time{}, "t")
+	if !b.CompareAndSwap("a", "va", "swapped") {
+		t.Error("Expecting swap")
+	}
+	if b.InvalidateTag("t") != 1 {
+		t.Error("Expecting CompareAndSwap to keep tags")
+	}
+
+	b.SetTags("a", "va", time.Time{}, "t")
+	b.Update("a", func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		return "updated", time.Time{}, true
+	})
+	if b.InvalidateTag("t") != 1 {
+		t.Error("Expecting Update to keep tags")
+	}
+
+	// Stale entry reloaded by GetOrLoad
+	b.SetTags("a", "va", time.Now().Add(-time.Second), "t")
+	b.GetOrLoad("a", func() (interface{}, time.Time, error) {
+		return "loaded", time.Time{}, nil
+	})
+	if v, _ := b.GetQuiet("a"); v != "loaded" || b.InvalidateTag("t") != 1 {
+		t.Error("Expecting load to keep tags")
+	}
+
+	// SetTags has no soft expiry, make it stale by hand
+	b.SetTags("a", "va", time.Time{}, "t")
+	b.writeLock()
+	b.table["a"].soft = time.Now().Add(-time.Second)
+	b.unlock()
+	b.Get("a")
+	waitLoad(t, b, "a")
+	if v, _ := b.GetQuiet("a"); v != "refreshed" || b.InvalidateTag("t") != 1 {
+		t.Error("Expecting refresh to keep tags")
+	}
+}
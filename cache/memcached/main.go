@@ -0,0 +1,46 @@
+// Cache server speaking the memcached text protocol, backed by
+// a MultiLRUCache. Try it with:
+//
+//	memcached -listen=127.0.0.1:11211 &
+//	printf "set a 0 60 2\r\nva\r\nget a\r\n" | nc 127.0.0.1 11211
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+func main() {
+	listen := flag.String("listen", ":11211", "address to listen on")
+	buckets := flag.Uint("buckets", 16, "number of buckets")
+	capacity := flag.Uint("capacity", 1024*1024, "max number of items")
+	maxBytes := flag.Int64("maxbytes", 64*1024*1024, "max total length of values, 0 for no limit")
+	maxItem := flag.Int("maxitem", 1024*1024, "max length of a value")
+	janitor := flag.Duration("janitor", 0, "how often to remove expired items, 0 to never")
+	flag.Parse()
+
+	// Global so that a popular bucket can use all the capacity.
+	// MaxSize is still enforced per bucket.
+	o := multilru.Options{Global: true}
+	o.JanitorInterval = *janitor
+	// The cache rounds the number of buckets up to a power of two.
+	n := uint(1)
+	for n < *buckets {
+		n <<= 1
+	}
+	if *maxBytes > 0 {
+		o.MaxSize = *maxBytes / int64(n)
+	}
+	m := multilru.NewMultiLRUCacheOptions(n, *capacity/n, o)
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", l.Addr())
+	log.Fatal(NewServer(m, *maxItem).Serve(l))
+}
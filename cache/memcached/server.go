@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+// Memcached text protocol, as described in doc/protocol.txt of the
+// memcached sources. Storage commands: set, add, replace, cas.
+// Retrieval: get, gets. Others: delete, touch, incr, decr,
+// flush_all, stats, version, quit.
+
+const (
+	maxKeyLength = 250
+	maxLineSize  = 8192
+
+	// Larger exptime is an absolute unix timestamp.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// Value stored in the cache.
+type item struct {
+	flags  uint32
+	data   []byte
+	cas    uint64
+	expire time.Time // same as in the cache, needed by Update
+}
+
+func (it *item) Size() int {
+	return len(it.data)
+}
+
+const errNonNumeric = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+
+type Server struct {
+	cache    *multilru.MultiLRUCache
+	maxItem  int           // max value length
+	cas      atomic.Uint64 // last cas unique
+	start    time.Time
+	conns    atomic.Int64 // current connections
+	total    atomic.Int64 // all connections
+	cmdTouch atomic.Uint64
+	cmdFlush atomic.Uint64
+}
+
+func NewServer(cache *multilru.MultiLRUCache, maxItem int) *Server {
+	return &Server{
+		cache:   cache,
+		maxItem: maxItem,
+		start:   time.Now(),
+	}
+}
+
+// Item under the key, without counting a get or changing its LRU
+// score. Expired items are missing.
+func (s *Server) peek(key string) (*item, bool) {
+	v, ok := s.cache.GetQuiet(key)
+	if !ok {
+		return nil, false
+	}
+	it := v.(*item)
+	if !it.expire.IsZero() && it.expire.Before(time.Now()) {
+		return nil, false
+	}
+	return it, true
+}
+
+// Accept connections until the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+type conn struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (s *Server) handle(nc net.Conn) {
+	defer nc.Close()
+	s.conns.Add(1)
+	s.total.Add(1)
+	defer s.conns.Add(-1)
+
+	c := &conn{
+		s: s,
+		r: bufio.NewReaderSize(nc, maxLineSize),
+		w: bufio.NewWriter(nc),
+	}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			c.w.WriteString("ERROR\r\n")
+		} else if !c.command(fields) {
+			c.w.Flush()
+			return
+		}
+		// Flush once there are no more pipelined commands.
+		if c.r.Buffered() == 0 {
+			if c.w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// Run a command. False if the connection should be closed.
+func (c *conn) command(f []string) bool {
+	switch f[0] {
+	case "get", "gets":
+		return c.get(f)
+	case "set", "add", "replace", "cas":
+		return c.store(f)
+	case "delete":
+		return c.delete(f)
+	case "touch":
+		return c.touch(f)
+	case "incr", "decr":
+		return c.incr(f)
+	case "flush_all":
+		return c.flushAll(f)
+	case "stats":
+		return c.stats(f)
+	case "version":
+		c.w.WriteString("VERSION goplayground-cache\r\n")
+	case "quit":
+		return false
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+func (c *conn) clientError(msg string) bool {
+	fmt.Fprintf(c.w, "CLIENT_ERROR %s\r\n", msg)
+	return true
+}
+
+// Reply unless the command ended with "noreply".
+func (c *conn) reply(noreply bool, msg string) bool {
+	if !noreply {
+		c.w.WriteString(msg)
+		c.w.WriteString("\r\n")
+	}
+	return true
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Expiry time for exptime from the protocol. Zero means never, up
+// to 30 days it's relative to now, above that a unix timestamp.
+// Negative means already expired.
+func expireTime(exptime int64, now time.Time) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return now.Add(-time.Second)
+	case exptime <= maxRelativeExptime:
+		return now.Add(time.Duration(exptime) * time.Second)
+	}
+	return time.Unix(exptime, 0)
+}
+
+func (c *conn) get(f []string) bool {
+	if len(f) < 2 {
+		return c.reply(false, "ERROR")
+	}
+	for _, key := range f[1:] {
+		if !validKey(key) {
+			return c.clientError("bad command line format")
+		}
+	}
+	withCas := f[0] == "gets"
+	for _, key := range f[1:] {
+		v, ok := c.s.cache.GetNotStale(key)
+		if !ok {
+			continue
+		}
+		it := v.(*item)
+		fmt.Fprintf(c.w, "VALUE %s %d %d", key, it.flags, len(it.data))
+		if withCas {
+			fmt.Fprintf(c.w, " %d", it.cas)
+		}
+		c.w.WriteString("\r\n")
+		c.w.Write(it.data)
+		c.w.WriteString("\r\n")
+	}
+	c.w.WriteString("END\r\n")
+	return true
+}
+
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (c *conn) store(f []string) bool {
+	args := 5
+	if f[0] == "cas" {
+		args = 6
+	}
+	if len(f) < args || len(f) > args+1 {
+		return c.reply(false, "ERROR")
+	}
+	noreply := len(f) == args+1 && f[args] == "noreply"
+
+	key := f[1]
+	flags, err1 := strconv.ParseUint(f[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(f[3], 10, 64)
+	length, err3 := strconv.Atoi(f[4])
+	var unique uint64
+	var err4 error
+	if f[0] == "cas" {
+		unique, err4 = strconv.ParseUint(f[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || length < 0 || !validKey(key) {
+		return c.clientError("bad command line format")
+	}
+
+	if length > c.s.maxItem {
+		// Swallow the data, the connection stays usable.
+		if _, err := io.CopyN(io.Discard, c.r, int64(length)+2); err != nil {
+			return false
+		}
+		return c.reply(noreply, "SERVER_ERROR object too large for cache")
+	}
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return false
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		// Skip to the end of line, hopefully the next command.
+		if data[len(data)-1] != '\n' {
+			c.r.ReadSlice('\n')
+		}
+		return c.clientError("bad data chunk")
+	}
+
+	// Update stores whatever it keeps, so rejected commands are
+	// answered before it to leave the item alone.
+	if old, ok := c.s.peek(key); ok && f[0] == "add" {
+		return c.reply(noreply, "NOT_STORED")
+	} else if ok && f[0] == "cas" && old.cas != unique {
+		return c.reply(noreply, "EXISTS")
+	}
+
+	expire := expireTime(exptime, time.Now())
+	it := &item{
+		flags:  uint32(flags),
+		data:   data[:length],
+		cas:    c.s.cas.Add(1),
+		expire: expire,
+	}
+
+	result := "STORED"
+	_, stored := c.s.cache.Update(key, func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		// Rejecting here only happens when racing with another
+		// command, keeping the old item rewrites it.
+		switch {
+		case f[0] == "add" && ok:
+			result = "NOT_STORED"
+			return old, old.(*item).expire, true
+		case f[0] == "replace" && !ok:
+			result = "NOT_STORED"
+			return nil, time.Time{}, false
+		case f[0] == "cas" && !ok:
+			result = "NOT_FOUND"
+			return nil, time.Time{}, false
+		case f[0] == "cas" && old.(*item).cas != unique:
+			result = "EXISTS"
+			return old, old.(*item).expire, true
+		}
+		return it, expire, true
+	})
+	if result == "STORED" && !stored {
+		// Bigger than MaxSize of the bucket, or no room for it.
+		result = "SERVER_ERROR out of memory storing object"
+	}
+	return c.reply(noreply, result)
+}
+
+// delete <key> [noreply]
+func (c *conn) delete(f []string) bool {
+	if len(f) < 2 || len(f) > 3 {
+		return c.reply(false, "ERROR")
+	}
+	noreply := len(f) == 3 && f[2] == "noreply"
+	if !validKey(f[1]) {
+		return c.clientError("bad command line format")
+	}
+
+	// Stale entries are as good as missing.
+	found := false
+	c.s.cache.Update(f[1], func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		found = ok
+		return nil, time.Time{}, false
+	})
+	if !found {
+		return c.reply(noreply, "NOT_FOUND")
+	}
+	return c.reply(noreply, "DELETED")
+}
+
+// touch <key> <exptime> [noreply]
+func (c *conn) touch(f []string) bool {
+	if len(f) < 3 || len(f) > 4 {
+		return c.reply(false, "ERROR")
+	}
+	noreply := len(f) == 4 && f[3] == "noreply"
+	exptime, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil || !validKey(f[1]) {
+		return c.clientError("bad command line format")
+	}
+	c.s.cmdTouch.Add(1)
+
+	// Update rather than Touch, the item keeps a copy of expiry.
+	expire := expireTime(exptime, time.Now())
+	found := false
+	c.s.cache.Update(f[1], func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		if !ok {
+			return nil, time.Time{}, false
+		}
+		found = true
+		it := *old.(*item)
+		it.expire = expire
+		return &it, expire, true
+	})
+	if !found {
+		return c.reply(noreply, "NOT_FOUND")
+	}
+	return c.reply(noreply, "TOUCHED")
+}
+
+// incr|decr <key> <value> [noreply]
+func (c *conn) incr(f []string) bool {
+	if len(f) < 3 || len(f) > 4 {
+		return c.reply(false, "ERROR")
+	}
+	noreply := len(f) == 4 && f[3] == "noreply"
+	if !validKey(f[1]) {
+		return c.clientError("bad command line format")
+	}
+	delta, err := strconv.ParseUint(f[2], 10, 64)
+	if err != nil {
+		return c.clientError("invalid numeric delta argument")
+	}
+	// Answered before Update, which would rewrite the item.
+	if old, ok := c.s.peek(f[1]); ok {
+		if _, err := strconv.ParseUint(string(old.data), 10, 64); err != nil {
+			return c.reply(noreply, errNonNumeric)
+		}
+	}
+
+	result := "NOT_FOUND"
+	c.s.cache.Update(f[1], func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		if !ok {
+			return nil, time.Time{}, false
+		}
+		o := old.(*item)
+		n, err := strconv.ParseUint(string(o.data), 10, 64)
+		if err != nil {
+			result = errNonNumeric
+			return old, o.expire, true
+		}
+		if f[0] == "incr" {
+			n += delta // wraps around, like memcached
+		} else if n < delta {
+			n = 0
+		} else {
+			n -= delta
+		}
+		result = strconv.FormatUint(n, 10)
+		return &item{
+			flags:  o.flags,
+			data:   []byte(result),
+			cas:    c.s.cas.Add(1),
+			expire: o.expire,
+		}, o.expire, true
+	})
+	return c.reply(noreply, result)
+}
+
+// flush_all [delay] [noreply]
+func (c *conn) flushAll(f []string) bool {
+	noreply := f[len(f)-1] == "noreply"
+	if noreply {
+		f = f[:len(f)-1]
+	}
+	if len(f) > 2 {
+		return c.reply(false, "ERROR")
+	}
+	var delay int64
+	if len(f) == 2 {
+		var err error
+		if delay, err = strconv.ParseInt(f[1], 10, 64); err != nil {
+			return c.clientError("bad command line format")
+		}
+	}
+	c.s.cmdFlush.Add(1)
+
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			c.s.cache.Clear()
+		})
+	} else {
+		c.s.cache.Clear()
+	}
+	return c.reply(noreply, "OK")
+}
+
+// stats, only the general ones
+func (c *conn) stats(f []string) bool {
+	if len(f) > 1 {
+		return c.reply(false, "ERROR")
+	}
+	s := c.s
+	st := s.cache.Stats()
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(c.w, "STAT %s %v\r\n", name, value)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.start).Seconds()))
+	stat("time", now.Unix())
+	stat("version", "goplayground-cache")
+	stat("curr_connections", s.conns.Load())
+	stat("total_connections", s.total.Load())
+	stat("cmd_get", st.Gets)
+	stat("cmd_set", st.Sets)
+	stat("cmd_flush", s.cmdFlush.Load())
+	stat("cmd_touch", s.cmdTouch.Load())
+	stat("get_hits", st.Hits)
+	stat("get_misses", st.Misses)
+	// Not memcached's expired_unfetched, all expired items count.
+	stat("expired", st.Expired)
+	stat("evictions", st.Evicted)
+	stat("curr_items", s.cache.Len())
+	stat("limit_items", s.cache.Capacity())
+	stat("bytes", s.cache.Size())
+	c.w.WriteString("END\r\n")
+	return true
+}
+
+// Values report their length, so that MaxSize limits bytes.
+var _ lrucache.Sizer = &item{}
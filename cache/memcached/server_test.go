@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+func startServer(t *testing.T) (net.Conn, *bufio.Reader) {
+	return startServerCache(t, multilru.NewMultiLRUCache(4, 16))
+}
+
+func startServerCache(t *testing.T, m *multilru.MultiLRUCache) (net.Conn, *bufio.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewServer(m, 100).Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	return c, bufio.NewReader(c)
+}
+
+// Send a request and check the response, lines separated with |.
+func expect(t *testing.T, c net.Conn, r *bufio.Reader, req, resp string) {
+	t.Helper()
+	if _, err := c.Write([]byte(strings.ReplaceAll(req, "|", "\r\n"))); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range strings.Split(resp, "|") {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSuffix(line, "\r\n"); line != want {
+			t.Fatalf("after %q expecting %q, got %q", req, want, line)
+		}
+	}
+}
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	expect(t, c, r, "get a|", "END")
+	expect(t, c, r, "set a 5 0 2|va|", "STORED")
+	expect(t, c, r, "get a b|", "VALUE a 5 2|va|END")
+	expect(t, c, r, "add a 0 0 2|vx|", "NOT_STORED")
+	expect(t, c, r, "add b 0 0 2|vb|", "STORED")
+	expect(t, c, r, "replace c 0 0 2|vc|", "NOT_STORED")
+	expect(t, c, r, "replace b 0 0 3|vb2|", "STORED")
+	expect(t, c, r, "get b a|", "VALUE b 0 3|vb2|VALUE a 5 2|va|END")
+
+	// Pipelined, with noreply
+	expect(t, c, r, "set c 0 0 1 noreply|x|set d 0 0 1 noreply|y|get c d|", "VALUE c 0 1|x|VALUE d 0 1|y|END")
+
+	expect(t, c, r, "delete a|", "DELETED")
+	expect(t, c, r, "delete a|", "NOT_FOUND")
+
+	expect(t, c, r, "set e 0 0 200|"+strings.Repeat("x", 200)+"|get e|", "SERVER_ERROR object too large for cache|END")
+	expect(t, c, r, "set e 0 0 1|xx|", "CLIENT_ERROR bad data chunk")
+	expect(t, c, r, "set e 0 x 1|", "CLIENT_ERROR bad command line format")
+	expect(t, c, r, "bogus|", "ERROR")
+}
+
+func TestOutOfMemory(t *testing.T) {
+	t.Parallel()
+	o := multilru.Options{}
+	o.MaxSize = 10
+	c, r := startServerCache(t, multilru.NewMultiLRUCacheOptions(4, 16, o))
+
+	big := strings.Repeat("x", 20)
+	expect(t, c, r, "set a 0 0 20|"+big+"|", "SERVER_ERROR out of memory storing object")
+	expect(t, c, r, "add a 0 0 20|"+big+"|", "SERVER_ERROR out of memory storing object")
+	expect(t, c, r, "set a 0 0 2|va|", "STORED")
+	expect(t, c, r, "replace a 0 0 20|"+big+"|get a|", "SERVER_ERROR out of memory storing object|END")
+}
+
+func TestCas(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	expect(t, c, r, "cas a 0 0 1 1|x|", "NOT_FOUND")
+	expect(t, c, r, "set a 0 0 1|x|", "STORED")
+	c.Write([]byte("gets a\r\n"))
+	line, _ := r.ReadString('\n')
+	f := strings.Fields(line)
+	if len(f) != 5 {
+		t.Fatalf("expecting cas unique %q", line)
+	}
+	r.ReadString('\n')
+	r.ReadString('\n')
+
+	expect(t, c, r, "cas a 0 0 1 "+f[4]+"|y|", "STORED")
+	expect(t, c, r, "cas a 0 0 1 "+f[4]+"|z|", "EXISTS")
+	expect(t, c, r, "get a|", "VALUE a 0 1|y|END")
+}
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	expect(t, c, r, "set a 0 -1 1|x|", "STORED")
+	expect(t, c, r, "get a|", "END")
+	expect(t, c, r, "touch a 100|", "NOT_FOUND")
+	expect(t, c, r, "set b 0 100 1|x|", "STORED")
+	expect(t, c, r, "touch b -1|", "TOUCHED")
+	expect(t, c, r, "get b|", "END")
+
+	// Absolute time in the future
+	expect(t, c, r, "set c 0 4000000000 1|x|", "STORED")
+	expect(t, c, r, "get c|", "VALUE c 0 1|x|END")
+
+	expect(t, c, r, "flush_all|", "OK")
+	expect(t, c, r, "get c|", "END")
+
+	now := time.Unix(1000000000, 0)
+	if !expireTime(0, now).IsZero() {
+		t.Error("expecting no expiry")
+	}
+	if !expireTime(60, now).Equal(now.Add(time.Minute)) {
+		t.Error("expecting relative expiry")
+	}
+	if !expireTime(maxRelativeExptime+1, now).Equal(time.Unix(maxRelativeExptime+1, 0)) {
+		t.Error("expecting absolute expiry")
+	}
+}
+
+func TestIncr(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	expect(t, c, r, "incr a 1|", "NOT_FOUND")
+	expect(t, c, r, "set a 3 0 2|10|", "STORED")
+	expect(t, c, r, "incr a 5|", "15")
+	expect(t, c, r, "decr a 20|", "0")
+	expect(t, c, r, "incr a 18446744073709551615|", "18446744073709551615")
+	expect(t, c, r, "incr a 2|", "1")
+	expect(t, c, r, "get a|", "VALUE a 3 1|1|END")
+	expect(t, c, r, "set b 0 0 1|x|", "STORED")
+	expect(t, c, r, "incr b 1|", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	expect(t, c, r, "incr b x|", "CLIENT_ERROR invalid numeric delta argument")
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	expect(t, c, r, "set a 0 0 2|va|set b 0 -1 1|x|get a b|", "STORED|STORED|VALUE a 0 2|va|END")
+	c.Write([]byte("stats\r\n"))
+	stats := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := strings.Fields(line)
+		if len(f) == 1 && f[0] == "END" {
+			break
+		}
+		stats[f[1]] = f[2]
+	}
+	if stats["get_hits"] != "1" || stats["get_misses"] != "1" || stats["curr_items"] != "1" || stats["bytes"] != "2" || stats["expired"] != "1" {
+		t.Errorf("expecting different stats %v", stats)
+	}
+	expect(t, c, r, "version|", "VERSION goplayground-cache")
+}
+
+func TestRejected(t *testing.T) {
+	t.Parallel()
+	c, r := startServerCache(t, multilru.NewMultiLRUCache(1, 2))
+
+	// Failed commands don't make a most recently used
+	expect(t, c, r, "set a 0 0 1|x|", "STORED")
+	expect(t, c, r, "set b 0 0 1|x|", "STORED")
+	expect(t, c, r, "add a 0 0 1|y|", "NOT_STORED")
+	expect(t, c, r, "cas a 0 0 1 0|y|", "EXISTS")
+	expect(t, c, r, "incr a 1|", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	expect(t, c, r, "set c 0 0 1|x|", "STORED")
+	expect(t, c, r, "get a b c|", "VALUE b 0 1|x|VALUE c 0 1|x|END")
+}
+
+func TestInvalidKey(t *testing.T) {
+	t.Parallel()
+	c, r := startServer(t)
+
+	long := strings.Repeat("k", maxKeyLength+1)
+	for _, req := range []string{
+		"get a " + long,
+		"gets " + long,
+		"delete " + long,
+		"touch " + long + " 0",
+		"incr " + long + " 1",
+		"decr " + long + " 1",
+	} {
+		expect(t, c, r, req+"|", "CLIENT_ERROR bad command line format")
+	}
+	expect(t, c, r, "get "+long[1:]+"|", "END")
+}
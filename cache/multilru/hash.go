@@ -0,0 +1,40 @@
+package multilru
+
+import (
+	"hash/crc32"
+	"hash/maphash"
+	"unsafe"
+)
+
+// Maps a key to a bucket. Should be fast and spread keys evenly.
+type HashFunc func(key string) uint64
+
+// Look at the key bytes without copying them. Only for hash
+// functions that don't hold on to the slice.
+func keyBytes(key string) []byte {
+	return unsafe.Slice(unsafe.StringData(key), len(key))
+}
+
+// CRC32 of the key. Predictable, so prone to hash flooding.
+func CRC32Hash(key string) uint64 {
+	return uint64(crc32.ChecksumIEEE(keyBytes(key)))
+}
+
+// FNV-1a of the key. Predictable, so prone to hash flooding.
+func FNVHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Hash function using maphash with a fresh random seed. Keys can't
+// be crafted to land in a single bucket without knowing the seed.
+func NewMapHash() HashFunc {
+	seed := maphash.MakeSeed()
+	return func(key string) uint64 {
+		return maphash.String(seed, key)
+	}
+}
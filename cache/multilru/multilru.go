@@ -1,37 +1,109 @@
 package multilru
 
 import (
+	"context"
 	"github.com/majek/goplayground/cache/lrucache"
-	"hash"
-	"hash/crc32"
+	"io"
 	"time"
 )
 
 type MultiLRUCache struct {
 	buckets uint
+	mask    uint
 	cache   []*lrucache.LRUCache
-	hash    hash.Hash
+	hash    HashFunc
+	codec   lrucache.Codec
+	pool    *lrucache.Pool // shared entries in Global mode
+	clock   lrucache.Clock
 }
 
+// Settings for the MultiLRUCache.
+type Options struct {
+	// Applied to every bucket separately, so for example MaxSize
+	// is a per bucket limit.
+	lrucache.Options
+
+	// Picks the bucket for a key. Defaults to CRC32Hash, so keys
+	// land in the same buckets on every run. Use NewMapHash() if
+	// the keys come from untrusted sources.
+	Hash HashFunc
+
+	// Enforce one capacity of buckets*bucket_capacity for the whole
+	// cache instead of a fixed one per bucket. Buckets take entries
+	// from a shared lrucache.Pool, so a bucket with many keys can
+	// grow while others are empty. When the cache is full the least
+	// recently used entry of all the buckets is evicted, as long as
+	// its bucket isn't busy.
+	Global bool
+}
 
 // Using this constructor is almost always wrong. Use NewMultiLRUCache instead.
 func (m *MultiLRUCache) Init(buckets, bucket_capacity uint) {
-	m.buckets = buckets
-	m.cache = make([]*lrucache.LRUCache, buckets)
-	for i := uint(0); i < buckets; i++ {
-		m.cache[i] = lrucache.NewLRUCache(bucket_capacity)
+	m.InitOptions(buckets, bucket_capacity, Options{})
+}
+
+// Using this constructor is almost always wrong. Use
+// NewMultiLRUCacheOptions instead. Number of buckets is rounded up
+// to a power of two.
+func (m *MultiLRUCache) InitOptions(buckets, bucket_capacity uint, o Options) {
+	n := uint(1)
+	for n < buckets {
+		n <<= 1
+	}
+	m.buckets = n
+	m.mask = n - 1
+	m.hash = o.Hash
+	if m.hash == nil {
+		m.hash = CRC32Hash
+	}
+	m.codec = o.Codec
+	if m.codec == nil {
+		m.codec = lrucache.GobCodec{}
+	}
+	m.clock = o.Clock
+	if m.clock == nil {
+		m.clock = lrucache.RealClock{}
+	}
+	m.pool = nil
+	if o.Global {
+		m.pool = lrucache.NewPool(n * bucket_capacity)
+		o.Pool = m.pool
+		if o.NegativeCapacity == 0 {
+			o.NegativeCapacity = bucket_capacity
+		}
+		bucket_capacity = 0
+	}
+	m.cache = make([]*lrucache.LRUCache, n)
+	for i := uint(0); i < n; i++ {
+		m.cache[i] = lrucache.NewLRUCacheOptions(bucket_capacity, o.Options)
 	}
 }
 
+// Create a cache of `buckets` LRUCaches, each one holding up to
+// `bucket_capacity` entries. Number of buckets is rounded up to a
+// power of two, so the total capacity can be larger than
+// buckets*bucket_capacity, see Buckets and Capacity.
 func NewMultiLRUCache(buckets, bucket_capacity uint) *MultiLRUCache {
 	m := &MultiLRUCache{}
 	m.Init(buckets, bucket_capacity)
 	return m
 }
 
+// Create a cache with extra settings. Number of buckets is rounded
+// up to a power of two, like in NewMultiLRUCache.
+func NewMultiLRUCacheOptions(buckets, bucket_capacity uint, o Options) *MultiLRUCache {
+	m := &MultiLRUCache{}
+	m.InitOptions(buckets, bucket_capacity, o)
+	return m
+}
+
+// Number of buckets, after rounding up to a power of two.
+func (m *MultiLRUCache) Buckets() int {
+	return int(m.buckets)
+}
+
 func (m *MultiLRUCache) bucketNo(key string) uint {
-	// Arbitrary choice. Any fast hash will do.
-	return uint(crc32.ChecksumIEEE([]byte(key))) % m.buckets
+	return uint(m.hash(key)) & m.mask
 }
 
 func (m *MultiLRUCache) Set(key string, value interface{}, expire time.Time) {
@@ -42,12 +114,36 @@ func (m *MultiLRUCache) SetNow(key string, value interface{}, expire time.Time,
 	m.cache[m.bucketNo(key)].SetNow(key, value, expire, now)
 }
 
+func (m *MultiLRUCache) SetSize(key string, value interface{}, size int64, expire time.Time) {
+	m.cache[m.bucketNo(key)].SetSize(key, value, size, expire)
+}
+
+func (m *MultiLRUCache) SetSoft(key string, value interface{}, soft, expire time.Time) {
+	m.cache[m.bucketNo(key)].SetSoft(key, value, soft, expire)
+}
+
+func (m *MultiLRUCache) SetIfAbsent(key string, value interface{}, expire time.Time) bool {
+	return m.cache[m.bucketNo(key)].SetIfAbsent(key, value, expire)
+}
+
+func (m *MultiLRUCache) CompareAndSwap(key string, old, new interface{}) bool {
+	return m.cache[m.bucketNo(key)].CompareAndSwap(key, old, new)
+}
+
+func (m *MultiLRUCache) Update(key string, fn lrucache.UpdateFunc) (value interface{}, ok bool) {
+	return m.cache[m.bucketNo(key)].Update(key, fn)
+}
+
+func (m *MultiLRUCache) SetTags(key string, value interface{}, expire time.Time, tags ...string) {
+	m.cache[m.bucketNo(key)].SetTags(key, value, expire, tags...)
+}
+
 func (m *MultiLRUCache) Get(key string) (value interface{}, ok bool) {
 	return m.cache[m.bucketNo(key)].Get(key)
 }
 
 func (m *MultiLRUCache) GetQuiet(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Get(key)
+	return m.cache[m.bucketNo(key)].GetQuiet(key)
 }
 
 func (m *MultiLRUCache) GetNotStale(key string) (value interface{}, ok bool) {
@@ -58,6 +154,22 @@ func (m *MultiLRUCache) GetNotStaleNow(key string, now time.Time) (value interfa
 	return m.cache[m.bucketNo(key)].GetNotStaleNow(key, now)
 }
 
+func (m *MultiLRUCache) GetOrLoad(key string, loader lrucache.LoadFunc) (value interface{}, err error) {
+	return m.cache[m.bucketNo(key)].GetOrLoad(key, loader)
+}
+
+func (m *MultiLRUCache) GetOrLoadCtx(ctx context.Context, key string, loader lrucache.LoadCtxFunc) (value interface{}, err error) {
+	return m.cache[m.bucketNo(key)].GetOrLoadCtx(ctx, key, loader)
+}
+
+func (m *MultiLRUCache) GetCtx(ctx context.Context, key string) (value interface{}, ok bool, err error) {
+	return m.cache[m.bucketNo(key)].GetCtx(ctx, key)
+}
+
+func (m *MultiLRUCache) Touch(key string, expire time.Time) bool {
+	return m.cache[m.bucketNo(key)].Touch(key, expire)
+}
+
 func (m *MultiLRUCache) Del(key string) (value interface{}, ok bool) {
 	return m.cache[m.bucketNo(key)].Del(key)
 }
@@ -70,6 +182,25 @@ func (m *MultiLRUCache) Clear() int {
 	return s
 }
 
+// Remove entries with a given tag from all the buckets.
+func (m *MultiLRUCache) InvalidateTag(tag string) int {
+	var s int
+	for _, c := range m.cache {
+		s += c.InvalidateTag(tag)
+	}
+	return s
+}
+
+// Remove entries with keys starting with a prefix from all the
+// buckets.
+func (m *MultiLRUCache) InvalidatePrefix(prefix string) int {
+	var s int
+	for _, c := range m.cache {
+		s += c.InvalidatePrefix(prefix)
+	}
+	return s
+}
+
 func (m *MultiLRUCache) Len() int {
 	var s int
 	for _, c := range m.cache {
@@ -79,6 +210,9 @@ func (m *MultiLRUCache) Len() int {
 }
 
 func (m *MultiLRUCache) Capacity() int {
+	if m.pool != nil {
+		return m.pool.Capacity()
+	}
 	var s int
 	for _, c := range m.cache {
 		s += c.Capacity()
@@ -86,6 +220,33 @@ func (m *MultiLRUCache) Capacity() int {
 	return s
 }
 
+// Change the total capacity. Without Global it's split evenly
+// between the buckets. See LRUCache.Resize.
+func (m *MultiLRUCache) Resize(capacity uint) {
+	if m.pool != nil {
+		m.pool.Resize(capacity)
+		return
+	}
+	n := uint(len(m.cache))
+	for i, c := range m.cache {
+		bucket := capacity / n
+		if uint(i) < capacity%n {
+			bucket += 1
+		}
+		c.Resize(bucket)
+	}
+}
+
+// Number of entries in every bucket, to see how evenly the keys are
+// spread.
+func (m *MultiLRUCache) Occupancy() []int {
+	o := make([]int, len(m.cache))
+	for i, c := range m.cache {
+		o[i] = c.Len()
+	}
+	return o
+}
+
 func (m *MultiLRUCache) Expire() int {
 	var s int
 	for _, c := range m.cache {
@@ -101,3 +262,100 @@ func (m *MultiLRUCache) ExpireNow(now time.Time) int {
 	}
 	return s
 }
+
+func (m *MultiLRUCache) Size() int64 {
+	var s int64
+	for _, c := range m.cache {
+		s += c.Size()
+	}
+	return s
+}
+
+// Sum of counters from all the buckets.
+func (m *MultiLRUCache) Stats() lrucache.Stats {
+	var s lrucache.Stats
+	for _, c := range m.cache {
+		s.Add(c.Stats())
+	}
+	return s
+}
+
+// Counters for every bucket separately, useful to spot skew.
+func (m *MultiLRUCache) ShardStats() []lrucache.Stats {
+	s := make([]lrucache.Stats, len(m.cache))
+	for i, c := range m.cache {
+		s[i] = c.Stats()
+	}
+	return s
+}
+
+// Write entries from all the buckets to w. The dump can be loaded
+// into a cache with a different number of buckets.
+func (m *MultiLRUCache) Dump(w io.Writer) error {
+	var items []lrucache.Item
+	for _, c := range m.cache {
+		items = append(items, c.Items()...)
+	}
+	return lrucache.DumpItems(w, m.codec, items)
+}
+
+// Read entries written by Dump, skipping the expired ones.
+func (m *MultiLRUCache) Load(r io.Reader) error {
+	now := m.clock.Now()
+	return lrucache.LoadItems(r, m.codec, func(it lrucache.Item) {
+		if !it.Expire.IsZero() && it.Expire.Before(now) {
+			return
+		}
+		m.cache[m.bucketNo(it.Key)].SetItem(it)
+	})
+}
+
+// Keys of all the entries, most recently used first within every
+// bucket.
+func (m *MultiLRUCache) Keys() []string {
+	var keys []string
+	for _, c := range m.cache {
+		keys = append(keys, c.Keys()...)
+	}
+	return keys
+}
+
+// Call fn for every entry until it returns false. Buckets are
+// visited one by one, each in LRU order, most recently used first.
+func (m *MultiLRUCache) Range(fn func(key string, value interface{}, expire time.Time) bool) {
+	stop := false
+	for _, c := range m.cache {
+		c.Range(func(key string, value interface{}, expire time.Time) bool {
+			stop = !fn(key, value, expire)
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// Call fn for every entry with expiry set, soonest to expire first
+// across all the buckets, until it returns false.
+func (m *MultiLRUCache) RangeExpiry(fn func(key string, value interface{}, expire time.Time) bool) {
+	var items []lrucache.Item
+	for _, c := range m.cache {
+		c.RangeExpiry(func(key string, value interface{}, expire time.Time) bool {
+			items = append(items, lrucache.Item{Key: key, Value: value, Expire: expire})
+			return true
+		})
+	}
+	lrucache.SortByExpiry(items)
+	for _, it := range items {
+		if !fn(it.Key, it.Value, it.Expire) {
+			return
+		}
+	}
+}
+
+// Stop the janitors of all the buckets.
+func (m *MultiLRUCache) Close() {
+	for _, c := range m.cache {
+		c.Close()
+	}
+}
@@ -1,7 +1,12 @@
 package multilru
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
 	"github.com/majek/goplayground/cache"
+	"github.com/majek/goplayground/cache/lrucache"
 	"testing"
 	"time"
 	"math/rand"
@@ -96,6 +101,36 @@ func TestBasic(t *testing.T) {
 }
 
 
+func TestMaxSize(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCacheOptions(2, 3, Options{Options: lrucache.Options{MaxSize: 10}})
+	m.SetSize("a", "va", 4, time.Time{})
+	m.SetSize("b", "vb", 4, time.Time{})
+	m.SetSize("c", "vc", 11, time.Time{})
+
+	if m.Size() != 8 || m.Len() != 2 {
+		t.Error("expecting different size")
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	t.Parallel()
+
+	evicted := 0
+	m := NewMultiLRUCacheOptions(2, 3, Options{Options: lrucache.Options{
+		OnEvict: func(key string, value interface{}, reason lrucache.EvictReason) {
+			evicted += 1
+		},
+	}})
+	for c := 'a'; c < 'z'; c = rune(int(c) + 1) {
+		m.Set(string(c), string([]rune{'v', c}), time.Time{})
+	}
+	m.Clear()
+	if evicted != 25 {
+		t.Error("expecting different evictions")
+	}
+}
 
 func randomString(l int) string {
 	bytes := make([]byte, l)
@@ -105,7 +140,7 @@ func randomString(l int) string {
 	return string(bytes)
 }
 
-func createFilledBucket(expire time.Time) cache.Cache {
+func createFilledBucket(expire time.Time) cache.AtomicCache {
 	b := NewMultiLRUCache(4, 250)
 	for i := 0; i < 1000; i++ {
 		b.Set(randomString(2), "value", expire)
@@ -160,7 +195,7 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 	ch := make(chan bool)
 	worker := func() {
 		for i := 0; i < bb.N/cpu; i++ {
-			b.Set(randomString(2), "v", time.Time{})
+			b.SetIfAbsent(randomString(2), "v", time.Time{})
 		}
 		ch <- true
 	}
@@ -171,3 +206,302 @@ func BenchmarkConcurrentSetNX(bb *testing.B) {
 		_ = <-ch
 	}
 }
+
+func TestGetOrLoad(t *testing.T) {
+	t.Parallel()
+
+	var c cache.LoadingCache = NewMultiLRUCache(2, 3)
+	loader := func() (interface{}, time.Time, error) {
+		return "va", time.Time{}, nil
+	}
+	if v, err := c.GetOrLoad("a", loader); v != "va" || err != nil {
+		t.Error("expecting value")
+	}
+	if v, _ := c.Get("a"); v != "va" {
+		t.Error("expecting hit")
+	}
+
+	ctx := context.Background()
+	if v, ok, err := c.GetCtx(ctx, "a"); v != "va" || !ok || err != nil {
+		t.Error("expecting hit")
+	}
+	v, err := c.GetOrLoadCtx(ctx, "b", func(ctx context.Context) (interface{}, time.Time, error) {
+		return "vb", time.Time{}, nil
+	})
+	if v != "vb" || err != nil {
+		t.Error("expecting value")
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(2, 3)
+	if !m.SetIfAbsent("a", 1, time.Time{}) || m.SetIfAbsent("a", 2, time.Time{}) {
+		t.Error("expecting one set")
+	}
+	if !m.CompareAndSwap("a", 1, 2) || m.CompareAndSwap("a", 1, 3) {
+		t.Error("expecting one swap")
+	}
+	v, ok := m.Update("a", func(old interface{}, ok bool) (interface{}, time.Time, bool) {
+		return old.(int) * 10, time.Time{}, true
+	})
+	if !ok || v != 20 {
+		t.Error("expecting update")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(4, 10)
+	for _, c := range "abcdef" {
+		m.SetTags("user:1:"+string(c), "v", time.Time{}, "user:1")
+		m.SetTags("user:2:"+string(c), "v", time.Time{}, "user:2")
+	}
+	if m.InvalidateTag("user:1") != 6 || m.Len() != 6 {
+		t.Error("expecting tagged keys removed")
+	}
+	if m.InvalidatePrefix("user:2:") != 6 || m.Len() != 0 {
+		t.Error("expecting prefixed keys removed")
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(2, 3)
+	for c := 'a'; c < 'z'; c = rune(int(c) + 1) {
+		m.Set(string(c), string([]rune{'v', c}), time.Time{})
+		m.Get(string(c))
+	}
+	m.Get("miss")
+	m.GetQuiet("y")
+	m.GetQuiet("miss")
+
+	s := m.Stats()
+	if s.Sets != 25 || s.Gets != 26 || s.Hits != 25 || s.Misses != 1 || s.Evicted != 19 {
+		t.Errorf("expecting different stats %+v", s)
+	}
+
+	var sum lrucache.Stats
+	for _, ss := range m.ShardStats() {
+		sum.Add(ss)
+	}
+	if sum != s {
+		t.Error("expecting shards to add up")
+	}
+}
+
+func TestDumpLoad(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCache(2, 3)
+	past := time.Now().Add(time.Duration(-10 * time.Second))
+	m.Set("a", "va", time.Time{})
+	m.Set("b", "vb", time.Time{})
+	m.Set("c", "vc", past)
+
+	var buf bytes.Buffer
+	if err := m.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewMultiLRUCache(3, 3)
+	if err := n.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n.Len() != 2 {
+		t.Error("expecting different length")
+	}
+	if v, _ := n.Get("b"); v != "vb" {
+		t.Error("expecting hit")
+	}
+}
+
+func TestRange(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCacheOptions(4, 3, Options{Hash: CRC32Hash})
+	now := time.Now()
+	for i, c := range "abcdef" {
+		m.Set(string(c), string([]rune{'v', c}), now.Add(time.Duration(-i)*time.Second))
+	}
+
+	if len(m.Keys()) != 6 {
+		t.Error("expecting different keys")
+	}
+
+	n := 0
+	m.Range(func(key string, value interface{}, expire time.Time) bool {
+		n += 1
+		return n < 4
+	})
+	if n != 4 {
+		t.Error("expecting early stop")
+	}
+
+	keys := ""
+	m.RangeExpiry(func(key string, value interface{}, expire time.Time) bool {
+		keys += key
+		return true
+	})
+	if keys != "fedcba" {
+		t.Errorf("expecting different order %q", keys)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultiLRUCacheOptions(2, 3, Options{Options: lrucache.Options{JanitorInterval: time.Millisecond}})
+	defer m.Close()
+
+	m.Set("a", "va", time.Now().Add(time.Duration(-1*time.Second)))
+	for i := 0; i < 1000 && m.Len() > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if m.Len() != 0 {
+		t.Error("expecting janitor to remove expired entries")
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	t.Parallel()
+
+	// All the keys land in one bucket
+	m := NewMultiLRUCacheOptions(4, 2, Options{
+		Global: true,
+		Hash:   func(key string) uint64 { return 0 },
+	})
+	if m.Capacity() != 8 {
+		t.Error("expecting global capacity")
+	}
+	for i := 0; i < 20; i++ {
+		m.Set(string(rune('a'+i)), "v", time.Time{})
+	}
+	if m.Len() != 8 {
+		t.Error("expecting full cache")
+	}
+	o := m.Occupancy()
+	if len(o) != 4 || o[0] != 8 || o[1] != 0 {
+		t.Errorf("expecting different occupancy %v", o)
+	}
+	if _, ok := m.Get("l"); ok {
+		t.Error("expecting miss")
+	}
+	if _, ok := m.Get("t"); !ok {
+		t.Error("expecting hit")
+	}
+
+	// Buckets still remember loader errors
+	opts := Options{Global: true}
+	opts.NegativeTTL = time.Hour
+	m = NewMultiLRUCacheOptions(4, 2, opts)
+	calls := 0
+	for i := 0; i < 2; i++ {
+		m.GetOrLoad("a", func() (interface{}, time.Time, error) {
+			calls += 1
+			return nil, time.Time{}, errors.New("fail")
+		})
+	}
+	if calls != 1 {
+		t.Error("expecting error to be cached")
+	}
+
+	// Keys spread over buckets still share the capacity
+	m = NewMultiLRUCacheOptions(4, 2, Options{Global: true})
+	for i := 0; i < 100; i++ {
+		m.Set(randomString(3), "v", time.Time{})
+	}
+	if m.Len() != 8 {
+		t.Error("expecting full cache")
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	for _, global := range []bool{false, true} {
+		m := NewMultiLRUCacheOptions(4, 4, Options{Global: global})
+		for i := 0; i < 100; i++ {
+			m.Set(randomString(3), "v", time.Time{})
+		}
+		m.Resize(6)
+		if m.Capacity() != 6 || m.Len() > 6 {
+			t.Errorf("expecting smaller cache, global=%v", global)
+		}
+		m.Resize(40)
+		for i := 0; i < 1000; i++ {
+			m.Set(randomString(3), "v", time.Time{})
+		}
+		if m.Capacity() != 40 || m.Len() < 30 {
+			t.Errorf("expecting bigger cache, global=%v", global)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	m := NewMultiLRUCacheOptions(3, 2, Options{Hash: FNVHash})
+	if m.Buckets() != 4 || m.Capacity() != 8 {
+		t.Error("expecting buckets rounded to power of two")
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(randomString(4), "v", time.Time{})
+	}
+	if m.Len() != 8 {
+		t.Error("expecting different length")
+	}
+
+	keys := []string{"", "a", "abc", randomString(100)}
+	for _, h := range []HashFunc{CRC32Hash, FNVHash, NewMapHash()} {
+		for _, k := range keys {
+			if h(k) != h(k) {
+				t.Error("expecting stable hash")
+			}
+		}
+		if h("a") == h("b") {
+			t.Error("expecting different hashes")
+		}
+	}
+
+	m = NewMultiLRUCache(4, 2)
+	allocs := testing.AllocsPerRun(100, func() {
+		m.bucketNo("some key")
+	})
+	if allocs != 0 {
+		t.Errorf("expecting no allocations, got %v", allocs)
+	}
+}
+
+var hashKey = "user:1234567:profile"
+
+// The hash used before, allocating a copy of the key.
+func BenchmarkHashCRC32Copy(bb *testing.B) {
+	bb.ReportAllocs()
+	for i := 0; i < bb.N; i++ {
+		crc32.ChecksumIEEE([]byte(hashKey + ""))
+	}
+}
+
+func BenchmarkHashCRC32(bb *testing.B) {
+	bb.ReportAllocs()
+	for i := 0; i < bb.N; i++ {
+		CRC32Hash(hashKey)
+	}
+}
+
+func BenchmarkHashFNV(bb *testing.B) {
+	bb.ReportAllocs()
+	for i := 0; i < bb.N; i++ {
+		FNVHash(hashKey)
+	}
+}
+
+func BenchmarkHashMapHash(bb *testing.B) {
+	h := NewMapHash()
+	bb.ReportAllocs()
+	for i := 0; i < bb.N; i++ {
+		h(hashKey)
+	}
+}
@@ -0,0 +1,299 @@
+// Cache shared by a group of processes, in the spirit of groupcache.
+//
+// Every key is owned by one peer, picked with a consistent hash
+// ring. The owner loads missing keys with the Loader and keeps them
+// in its LRUCache. Other peers fetch the key from the owner over
+// HTTP, and keep a copy for a short while in a small "hot" cache, so
+// that popular keys don't hammer the owner. Only a sample of fetches
+// is kept, popular keys get there soon enough while one-off ones
+// don't push them out. Concurrent requests for the same key are
+// collapsed into one, both for loads and fetches.
+
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+// Loads a value for a key owned by this peer.
+type Loader func(key string) (value []byte, expire time.Time, err error)
+
+// Settings for the Group.
+type Options struct {
+	// Capacity of the cache of remote keys. Defaults to 1/8 of
+	// the capacity of the group.
+	HotCapacity uint
+
+	// How long remote values are kept, unless they expire sooner.
+	// Defaults to a minute.
+	HotTTL time.Duration
+
+	// One in how many remote fetches is kept in the hot cache.
+	// Defaults to 10, as in groupcache. 1 keeps them all.
+	HotSampling int
+
+	// Points on the ring per peer. Defaults to 64.
+	Replicas int
+
+	// Prefix of the URL path served by the Group, must be the same
+	// on all the peers. Defaults to "/_cache/".
+	BasePath string
+
+	// Used to fetch from other peers. Defaults to a client with a
+	// 10 second timeout.
+	Client *http.Client
+}
+
+type Group struct {
+	self     string // own URL, as listed in SetPeers
+	loader   Loader
+	local    *lrucache.LRUCache // keys owned by this peer
+	hot      *lrucache.LRUCache // copies of remote keys
+	hotTTL   time.Duration
+	sampling int // keep one in that many fetches in hot
+	replicas int
+	basePath string
+	client   *http.Client
+	lock     sync.RWMutex // protects ring
+	ring     *Ring
+	stats    counters
+	fetching sync.Mutex       // protects fetches
+	fetches  map[string]*call // fetches in progress
+}
+
+// Counters, see Stats.
+type Stats struct {
+	Gets        uint64 // all Get calls
+	Loads       uint64 // Loader calls
+	PeerFetches uint64 // values fetched from other peers
+	PeerErrors  uint64 // failed fetches, loaded locally instead
+	ServerGets  uint64 // requests from other peers
+}
+
+type counters struct {
+	gets, loads, peerFetches, peerErrors, serverGets atomic.Uint64
+}
+
+const expireHeader = "X-Cache-Expire"
+
+// A fetch in progress. Waiters block on done, after that the other
+// fields are read only.
+type call struct {
+	done   chan struct{}
+	value  []byte
+	expire time.Time
+	err    error
+}
+
+// Value of an owned key. The expiry time is passed on to peers.
+type item struct {
+	value  []byte
+	expire time.Time
+}
+
+// Create a group member. `self` is the base URL of this peer, for
+// example "http://10.0.0.1:8000", as it appears in SetPeers. The
+// Group must be served under BasePath by the HTTP server at that
+// address.
+func NewGroup(self string, capacity uint, loader Loader, o Options) *Group {
+	if o.HotCapacity == 0 {
+		o.HotCapacity = capacity/8 + 1
+	}
+	if o.HotTTL == 0 {
+		o.HotTTL = time.Minute
+	}
+	if o.HotSampling <= 0 {
+		o.HotSampling = 10
+	}
+	if o.Replicas == 0 {
+		o.Replicas = 64
+	}
+	if o.BasePath == "" {
+		o.BasePath = "/_cache/"
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Group{
+		self:     strings.TrimSuffix(self, "/"),
+		loader:   loader,
+		local:    lrucache.NewLRUCache(capacity),
+		hot:      lrucache.NewLRUCache(o.HotCapacity),
+		hotTTL:   o.HotTTL,
+		sampling: o.HotSampling,
+		replicas: o.Replicas,
+		basePath: o.BasePath,
+		client:   o.Client,
+		ring:     NewRing(o.Replicas, nil),
+		fetches:  make(map[string]*call),
+	}
+}
+
+// Set the URLs of all the peers, including this one. Keys of peers
+// that are gone move to the remaining ones. Remote copies in the hot
+// cache are dropped, as they may belong to this peer now.
+func (g *Group) SetPeers(peers ...string) {
+	ring := NewRing(g.replicas, nil)
+	for _, peer := range peers {
+		ring.Add(strings.TrimSuffix(peer, "/"))
+	}
+
+	g.lock.Lock()
+	g.ring = ring
+	g.lock.Unlock()
+	g.hot.Clear()
+}
+
+// Peer owning the key, empty if it's this one.
+func (g *Group) owner(key string) string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	peer := g.ring.Get(key)
+	if peer == g.self {
+		return ""
+	}
+	return peer
+}
+
+// Get a value, from the owner of the key.
+func (g *Group) Get(key string) ([]byte, error) {
+	g.stats.gets.Add(1)
+	peer := g.owner(key)
+	if peer == "" {
+		it, err := g.getLocal(key)
+		return it.value, err
+	}
+
+	if v, ok := g.hot.GetNotStale(key); ok {
+		return v.([]byte), nil
+	}
+	return g.getRemote(peer, key)
+}
+
+// Fetch a key from its owner, or load it here if the owner fails.
+// Concurrent calls for the same key share one fetch.
+func (g *Group) getRemote(peer, key string) ([]byte, error) {
+	g.fetching.Lock()
+	if c := g.fetches[key]; c != nil {
+		g.fetching.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.fetches[key] = c
+	g.fetching.Unlock()
+
+	defer func() {
+		g.fetching.Lock()
+		delete(g.fetches, key)
+		g.fetching.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.expire, c.err = g.fetch(peer, key)
+	if c.err != nil {
+		// The owner might be down, don't fail because of that.
+		g.stats.peerErrors.Add(1)
+		c.value, c.expire, c.err = g.load(key)
+	} else {
+		g.stats.peerFetches.Add(1)
+	}
+	if c.err == nil && rand.IntN(g.sampling) == 0 {
+		expire := c.expire
+		if hot := time.Now().Add(g.hotTTL); expire.IsZero() || hot.Before(expire) {
+			expire = hot
+		}
+		g.hot.Set(key, c.value, expire)
+	}
+	return c.value, c.err
+}
+
+func (g *Group) getLocal(key string) (item, error) {
+	v, err := g.local.GetOrLoad(key, func() (interface{}, time.Time, error) {
+		value, expire, err := g.load(key)
+		return item{value, expire}, expire, err
+	})
+	if err != nil {
+		return item{}, err
+	}
+	return v.(item), nil
+}
+
+func (g *Group) load(key string) ([]byte, time.Time, error) {
+	g.stats.loads.Add(1)
+	return g.loader(key)
+}
+
+// Ask the owner of the key.
+func (g *Group) fetch(peer, key string) ([]byte, time.Time, error) {
+	resp, err := g.client.Get(peer + g.basePath + url.PathEscape(key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("peers: %s: %s: %s", peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var expire time.Time
+	if h := resp.Header.Get(expireHeader); h != "" {
+		nano, err := strconv.ParseInt(h, 10, 64)
+		if err != nil {
+			return nil, time.Time{}, errors.New("peers: bad " + expireHeader)
+		}
+		expire = time.Unix(0, nano)
+	}
+	return body, expire, nil
+}
+
+// Serve keys to other peers. Keys are always loaded locally, even if
+// this peer doesn't think it owns them, so requests never bounce
+// between peers with different views of the ring.
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, g.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, g.basePath)
+	g.stats.serverGets.Add(1)
+
+	it, err := g.getLocal(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !it.expire.IsZero() {
+		w.Header().Set(expireHeader, strconv.FormatInt(it.expire.UnixNano(), 10))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(it.value)
+}
+
+// Get the counters.
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:        g.stats.gets.Load(),
+		Loads:       g.stats.loads.Load(),
+		PeerFetches: g.stats.peerFetches.Load(),
+		PeerErrors:  g.stats.peerErrors.Load(),
+		ServerGets:  g.stats.serverGets.Load(),
+	}
+}
@@ -0,0 +1,192 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	t.Parallel()
+
+	r := NewRing(64, nil)
+	if r.Get("a") != "" {
+		t.Error("expecting no owner")
+	}
+	r.Add("p1", "p2", "p3")
+
+	owners := map[string]string{}
+	count := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners[key] = r.Get(key)
+		count[owners[key]] += 1
+	}
+	for _, p := range []string{"p1", "p2", "p3"} {
+		if count[p] < 500 {
+			t.Errorf("expecting keys spread over peers %v", count)
+		}
+	}
+
+	// Only keys of the new peer move
+	r.Add("p4")
+	moved := 0
+	for key, owner := range owners {
+		if o := r.Get(key); o != owner {
+			moved += 1
+			if o != "p4" {
+				t.Fatal("expecting keys to move to the new peer only")
+			}
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Errorf("expecting some keys to move, moved %d", moved)
+	}
+}
+
+type cluster struct {
+	groups  []*Group
+	servers []*httptest.Server
+	loads   map[string]int
+	lock    sync.Mutex
+}
+
+func newCluster(t *testing.T, n int, o Options) *cluster {
+	c := &cluster{loads: map[string]int{}}
+	var urls []string
+	for i := 0; i < n; i++ {
+		mux := http.NewServeMux()
+		s := httptest.NewServer(mux)
+		t.Cleanup(s.Close)
+		g := NewGroup(s.URL, 100, func(key string) ([]byte, time.Time, error) {
+			c.lock.Lock()
+			c.loads[key] += 1
+			c.lock.Unlock()
+			if key == "bad" {
+				return nil, time.Time{}, errors.New("bad key")
+			}
+			return []byte("v" + key), time.Time{}, nil
+		}, o)
+		mux.Handle("/_cache/", g)
+		c.groups = append(c.groups, g)
+		c.servers = append(c.servers, s)
+		urls = append(urls, s.URL)
+	}
+	for _, g := range c.groups {
+		g.SetPeers(urls...)
+	}
+	return c
+}
+
+func TestGroup(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, Options{HotSampling: 1})
+
+	// Every key is loaded once, by its owner
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key/%d", i)
+		for _, g := range c.groups {
+			v, err := g.Get(key)
+			if err != nil || string(v) != "v"+key {
+				t.Fatalf("expecting value %q %v", v, err)
+			}
+		}
+	}
+	for key, n := range c.loads {
+		if n != 1 {
+			t.Errorf("expecting one load of %q, got %d", key, n)
+		}
+	}
+
+	var fetches, served uint64
+	for _, g := range c.groups {
+		s := g.Stats()
+		fetches += s.PeerFetches
+		served += s.ServerGets
+	}
+	if fetches != 60 || served != 60 {
+		t.Errorf("expecting fetches from owners %d %d", fetches, served)
+	}
+
+	// Second round comes from the hot caches, for recent keys
+	for _, g := range c.groups {
+		g.Get("key/29")
+	}
+	var after uint64
+	for _, g := range c.groups {
+		after += g.Stats().PeerFetches
+	}
+	if after != fetches {
+		t.Error("expecting hot cache hits")
+	}
+
+	if _, err := c.groups[0].Get("bad"); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestSingleflight(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 2, Options{})
+
+	var wg sync.WaitGroup
+	var errs atomic.Int32
+	for i := 0; i < 20; i++ {
+		for _, g := range c.groups {
+			wg.Add(1)
+			go func(g *Group) {
+				defer wg.Done()
+				if _, err := g.Get("popular"); err != nil {
+					errs.Add(1)
+				}
+			}(g)
+		}
+	}
+	wg.Wait()
+	if errs.Load() != 0 || c.loads["popular"] != 1 {
+		t.Errorf("expecting one load, got %d", c.loads["popular"])
+	}
+}
+
+func TestPeerDown(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 2, Options{})
+	c.servers[1].Close()
+
+	// Keys of the dead peer are loaded locally
+	for i := 0; i < 20; i++ {
+		if _, err := c.groups[0].Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.groups[0].Stats().PeerErrors == 0 {
+		t.Error("expecting peer errors")
+	}
+
+	// Until the peer is removed
+	c.groups[0].SetPeers(c.servers[0].URL)
+	c.groups[0].Get("other")
+	if c.groups[0].Stats().PeerErrors+c.groups[0].Stats().PeerFetches > 20 {
+		t.Error("expecting no fetches")
+	}
+}
+
+func TestHotSampling(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 2, Options{HotCapacity: 1000})
+	g := c.groups[0]
+
+	for i := 0; i < 300; i++ {
+		g.Get(fmt.Sprintf("key%d", i))
+	}
+	// About one in ten remote keys is kept
+	remote := int(g.Stats().PeerFetches)
+	if n := g.hot.Len(); n == 0 || n > remote/2 {
+		t.Errorf("expecting a sample of %d fetches in hot, got %d", remote, n)
+	}
+}
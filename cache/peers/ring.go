@@ -0,0 +1,62 @@
+package peers
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/majek/goplayground/cache/multilru"
+)
+
+// Consistent hash ring. Every peer is placed on the ring a number of
+// times, a key belongs to the first peer point following the key
+// hash. Adding or removing a peer only moves keys of that peer.
+// Not safe for concurrent use.
+type Ring struct {
+	hash     multilru.HashFunc
+	replicas int
+	points   []uint64          // sorted
+	owners   map[uint64]string // peer owning the point
+}
+
+// Create an empty ring with `replicas` points per peer. The hash
+// must be the same on all the peers, defaults to CRC32.
+func NewRing(replicas int, hash multilru.HashFunc) *Ring {
+	if hash == nil {
+		hash = multilru.CRC32Hash
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &Ring{
+		hash:     hash,
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+	}
+}
+
+// Place peers on the ring.
+func (r *Ring) Add(peers ...string) {
+	for _, peer := range peers {
+		for i := 0; i < r.replicas; i++ {
+			p := r.hash(strconv.Itoa(i) + peer)
+			if _, ok := r.owners[p]; !ok {
+				r.points = append(r.points, p)
+			}
+			r.owners[p] = peer
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Peer owning the key, empty if there are no peers.
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := r.hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
@@ -0,0 +1,275 @@
+package tiered
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+// Values kept in an append-only log file. The index, mapping keys to
+// records in the log, lives in memory in an LRUCache, which takes
+// care of the capacity and expiry. Records dropped from the index
+// are garbage, reclaimed by rewriting the log with Compact.
+//
+// The log is not meant to survive a restart, it's truncated when
+// the store is created.
+type DiskStore struct {
+	lock        sync.Mutex
+	path        string
+	file        *os.File
+	codec       lrucache.Codec
+	clock       lrucache.Clock     // for the index, may be nil
+	index       *lrucache.LRUCache // key -> record
+	capacity    uint               // max number of records in index
+	size        int64              // length of the log
+	garbage     int64              // bytes of dropped records
+	compactSize int64              // min garbage to compact
+}
+
+// Location of a value in the log.
+type record struct {
+	offset int64     // start of the value
+	length int64     // length of the value
+	total  int64     // length of the whole record
+	expire time.Time // same as in the index
+}
+
+var ErrCorrupted = errors.New("tiered: corrupted record")
+
+// Create a store, truncating the file at path. Keeps at most
+// `capacity` values, least recently used are dropped first. The log
+// is compacted once it has at least compactSize bytes of garbage,
+// and garbage is more than half of it. Expiry is checked with clock,
+// RealClock if nil.
+func NewDiskStore(path string, capacity uint, codec lrucache.Codec, clock lrucache.Clock, compactSize int64) (*DiskStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		codec = lrucache.GobCodec{}
+	}
+	d := &DiskStore{
+		path:        path,
+		file:        file,
+		codec:       codec,
+		clock:       clock,
+		capacity:    capacity,
+		compactSize: compactSize,
+	}
+	d.index = d.newIndex()
+	return d, nil
+}
+
+func (d *DiskStore) newIndex() *lrucache.LRUCache {
+	// Callbacks run in the goroutine changing the index, which
+	// holds d.lock.
+	return lrucache.NewLRUCacheOptions(d.capacity, lrucache.Options{
+		Clock: d.clock,
+		OnEvict: func(key string, value interface{}, reason lrucache.EvictReason) {
+			d.garbage += value.(record).total
+		},
+	})
+}
+
+// Append a value to the log, overwriting the previous one. O(log(n))
+// if expiry is set, O(1) when clear.
+func (d *DiskStore) Set(key string, value interface{}, expire time.Time) error {
+	data, err := d.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("tiered: can't marshal %q: %v", key, err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	rec, err := d.write(d.file, d.size, key, expire, data)
+	if err != nil {
+		return err
+	}
+	d.size += rec.total
+	d.index.Set(key, rec, expire)
+	return d.maybeCompact()
+}
+
+// Record layout: uvarint key length, key, varint expire in unix
+// nanoseconds, uvarint value length, value.
+func (d *DiskStore) write(file *os.File, offset int64, key string, expire time.Time, data []byte) (record, error) {
+	buf := make([]byte, 0, 3*binary.MaxVarintLen64+len(key)+len(data))
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	var nano int64
+	if !expire.IsZero() {
+		nano = expire.UnixNano()
+	}
+	buf = binary.AppendVarint(buf, nano)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	rec := record{
+		offset: offset + int64(len(buf)),
+		length: int64(len(data)),
+		total:  int64(len(buf) + len(data)),
+		expire: expire,
+	}
+	buf = append(buf, data...)
+	if _, err := file.WriteAt(buf, offset); err != nil {
+		return record{}, err
+	}
+	return rec, nil
+}
+
+func (d *DiskStore) read(rec record) ([]byte, error) {
+	data := make([]byte, rec.length)
+	if _, err := d.file.ReadAt(data, rec.offset); err != nil {
+		return nil, ErrCorrupted
+	}
+	return data, nil
+}
+
+// Get a value and its expiry time, possibly stale. Update its LRU
+// score.
+func (d *DiskStore) Get(key string) (value interface{}, expire time.Time, ok bool, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	v, ok := d.index.Get(key)
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	rec := v.(record)
+	data, err := d.read(rec)
+	if err == nil {
+		value, err = d.codec.Unmarshal(data)
+	}
+	if err != nil {
+		d.index.Del(key)
+		return nil, time.Time{}, false, err
+	}
+	return value, rec.expire, true, nil
+}
+
+// Drop a value. The space is reclaimed on compaction.
+func (d *DiskStore) Del(key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	_, ok := d.index.Del(key)
+	return ok
+}
+
+// Drop expired values.
+func (d *DiskStore) ExpireNow(now time.Time) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.index.ExpireNow(now)
+}
+
+// Drop all the values and truncate the log. Returns the number of
+// dropped values. If truncating fails the values are dropped anyway,
+// and the whole log counts as garbage.
+func (d *DiskStore) Clear() (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	n := d.index.Len()
+	d.index = d.newIndex()
+	if err := d.file.Truncate(0); err != nil {
+		d.garbage = d.size
+		return n, err
+	}
+	d.size, d.garbage = 0, 0
+	return n, nil
+}
+
+// Number of values.
+func (d *DiskStore) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.index.Len()
+}
+
+// Max number of values.
+func (d *DiskStore) Capacity() int {
+	return int(d.capacity)
+}
+
+// Length of the log and how much of it is garbage.
+func (d *DiskStore) Size() (size, garbage int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.size, d.garbage
+}
+
+func (d *DiskStore) maybeCompact() error {
+	if d.garbage < d.compactSize || d.garbage*2 < d.size {
+		return nil
+	}
+	return d.compact()
+}
+
+// Rewrite the log, leaving only values in the index.
+func (d *DiskStore) Compact() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.compact()
+}
+
+func (d *DiskStore) compact() error {
+	tmp := d.path + ".compact"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Items come least recently used first, adding them in order
+	// keeps the LRU order.
+	index := d.newIndex()
+	var size int64
+	for _, it := range d.index.Items() {
+		data, err := d.read(it.Value.(record))
+		if err == nil {
+			var rec record
+			rec, err = d.write(file, size, it.Key, it.Expire, data)
+			size += rec.total
+			it.Value = rec
+			index.SetItem(it)
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, d.path); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	d.file.Close()
+	d.file = file
+	d.index = index
+	d.size, d.garbage = size, 0
+	return nil
+}
+
+// Close and remove the log.
+func (d *DiskStore) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.index.Clear()
+	err := d.file.Close()
+	if rerr := os.Remove(d.path); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+// Two level cache: an LRUCache in memory, backed by a DiskStore.
+//
+// Entries pushed out of memory to make room are written to disk
+// instead of being dropped. A Get missing memory looks on disk and
+// moves a hit back to memory, keeping its expiry time. An entry is
+// in at most one of the levels at a time.
+
+package tiered
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+type TieredCache struct {
+	lock  sync.Mutex // held while moving entries between levels
+	mem   *lrucache.LRUCache
+	disk  *DiskStore
+	clock lrucache.Clock
+	stats counters
+}
+
+// Settings for the TieredCache.
+type Options struct {
+	// Settings of the memory level. OnEvict, Refresh and Pool
+	// are not supported. Codec is used for values on disk.
+	Memory lrucache.Options
+
+	// Max number of entries on disk. Defaults to ten times the
+	// memory capacity.
+	DiskCapacity uint
+
+	// Rewrite the disk log once that many bytes are garbage, and
+	// at least half of the log. Defaults to 1MB.
+	CompactSize int64
+}
+
+// Counters, see Stats.
+type Stats struct {
+	MemHits  uint64 // hits in memory
+	DiskHits uint64 // hits on disk, moved back to memory
+	Misses   uint64 //
+	Spills   uint64 // entries written to disk
+	Errors   uint64 // failed disk reads and writes
+}
+
+type counters struct {
+	memHits, diskHits, misses, spills, errors atomic.Uint64
+}
+
+// Value in memory. The expiry time is needed when spilling, and the
+// OnEvict callback doesn't get it.
+type item struct {
+	value  interface{}
+	expire time.Time
+}
+
+func (i item) Size() int {
+	if s, ok := i.value.(lrucache.Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
+// Create a cache keeping `capacity` entries in memory and spilling
+// the rest to a log at path. The file is truncated.
+func NewTieredCache(path string, capacity uint, o Options) (*TieredCache, error) {
+	if o.DiskCapacity == 0 {
+		o.DiskCapacity = 10 * capacity
+	}
+	if o.CompactSize == 0 {
+		o.CompactSize = 1 << 20
+	}
+	disk, err := NewDiskStore(path, o.DiskCapacity, o.Memory.Codec, o.Memory.Clock, o.CompactSize)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TieredCache{disk: disk, clock: o.Memory.Clock}
+	if t.clock == nil {
+		t.clock = lrucache.RealClock{}
+	}
+	mo := o.Memory
+	mo.OnEvict = t.spill
+	mo.Refresh = nil
+	mo.Pool = nil
+	t.mem = lrucache.NewLRUCacheOptions(capacity, mo)
+	return t, nil
+}
+
+// OnEvict callback of the memory level. Runs in the goroutine that
+// changed the cache.
+func (t *TieredCache) spill(key string, value interface{}, reason lrucache.EvictReason) {
+	if reason != lrucache.EvictLRU {
+		return
+	}
+	it := value.(item)
+	if err := t.disk.Set(key, it.value, it.expire); err != nil {
+		t.stats.errors.Add(1)
+		return
+	}
+	t.stats.spills.Add(1)
+}
+
+// Look on disk after a miss in memory. Zero `now` means stale
+// entries are fine.
+func (t *TieredCache) getDisk(key string, promote bool, now time.Time) (value interface{}, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Somebody might have moved it to memory meanwhile.
+	if v, ok := t.mem.GetQuiet(key); ok {
+		t.stats.memHits.Add(1)
+		return v.(item).value, true
+	}
+
+	value, expire, ok, err := t.disk.Get(key)
+	if err != nil {
+		t.stats.errors.Add(1)
+	}
+	if ok && !now.IsZero() && !expire.IsZero() && expire.Before(now) {
+		t.disk.Del(key)
+		ok = false
+	}
+	if !ok {
+		t.stats.misses.Add(1)
+		return nil, false
+	}
+
+	t.stats.diskHits.Add(1)
+	if promote {
+		t.disk.Del(key)
+		t.mem.Set(key, item{value, expire}, expire)
+	}
+	return value, true
+}
+
+// Get a key from the cache, possibly stale. Hits on disk are moved
+// to memory.
+func (t *TieredCache) Get(key string) (value interface{}, ok bool) {
+	if v, ok := t.mem.Get(key); ok {
+		t.stats.memHits.Add(1)
+		return v.(item).value, true
+	}
+	return t.getDisk(key, true, time.Time{})
+}
+
+// Get a key from the cache, possibly stale. Doesn't move hits on
+// disk to memory.
+func (t *TieredCache) GetQuiet(key string) (value interface{}, ok bool) {
+	if v, ok := t.mem.GetQuiet(key); ok {
+		return v.(item).value, true
+	}
+	return t.getDisk(key, false, time.Time{})
+}
+
+// Get a key from the cache, make sure it's not stale. Hits on disk
+// are moved to memory.
+func (t *TieredCache) GetNotStale(key string) (value interface{}, ok bool) {
+	return t.GetNotStaleNow(key, t.clock.Now())
+}
+
+func (t *TieredCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
+	if v, ok := t.mem.GetNotStaleNow(key, now); ok {
+		t.stats.memHits.Add(1)
+		return v.(item).value, true
+	}
+	return t.getDisk(key, true, now)
+}
+
+// Add an item to memory, overwriting existing one. Might push the
+// least used entry to disk.
+func (t *TieredCache) Set(key string, value interface{}, expire time.Time) {
+	t.SetNow(key, value, expire, time.Time{})
+}
+
+func (t *TieredCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.disk.Del(key)
+	t.mem.SetNow(key, item{value, expire}, expire, now)
+}
+
+// Get and remove a key from both levels.
+func (t *TieredCache) Del(key string) (value interface{}, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if v, ok := t.mem.Del(key); ok {
+		t.disk.Del(key)
+		return v.(item).value, true
+	}
+	value, _, ok, _ = t.disk.Get(key)
+	if ok {
+		t.disk.Del(key)
+	}
+	return value, ok
+}
+
+// Evict all items from both levels.
+func (t *TieredCache) Clear() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	n, err := t.disk.Clear()
+	if err != nil {
+		t.stats.errors.Add(1)
+	}
+	return t.mem.Clear() + n
+}
+
+// Evict expired items from both levels.
+func (t *TieredCache) Expire() int {
+	return t.ExpireNow(t.clock.Now())
+}
+
+func (t *TieredCache) ExpireNow(now time.Time) int {
+	return t.mem.ExpireNow(now) + t.disk.ExpireNow(now)
+}
+
+// Number of entries in both levels.
+func (t *TieredCache) Len() int {
+	return t.mem.Len() + t.disk.Len()
+}
+
+// Max number of entries in both levels.
+func (t *TieredCache) Capacity() int {
+	return t.mem.Capacity() + t.disk.Capacity()
+}
+
+// Get the counters.
+func (t *TieredCache) Stats() Stats {
+	return Stats{
+		MemHits:  t.stats.memHits.Load(),
+		DiskHits: t.stats.diskHits.Load(),
+		Misses:   t.stats.misses.Load(),
+		Spills:   t.stats.spills.Load(),
+		Errors:   t.stats.errors.Load(),
+	}
+}
+
+// Stop the memory janitor, if any, and remove the disk log.
+func (t *TieredCache) Close() error {
+	t.mem.Close()
+	return t.disk.Close()
+}
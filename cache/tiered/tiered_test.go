@@ -0,0 +1,154 @@
+package tiered
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/majek/goplayground/cache"
+	"github.com/majek/goplayground/cache/lrucache"
+)
+
+func newTiered(t *testing.T, capacity uint, o Options) *TieredCache {
+	c, err := NewTieredCache(filepath.Join(t.TempDir(), "log"), capacity, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSpill(t *testing.T) {
+	t.Parallel()
+	c := newTiered(t, 2, Options{})
+	defer c.Close()
+	var _ cache.Cache = c
+
+	c.Set("a", "va", time.Time{})
+	c.Set("b", "vb", time.Time{})
+	c.Set("c", "vc", time.Time{})
+	if c.mem.Len() != 2 || c.disk.Len() != 1 || c.Len() != 3 {
+		t.Error("Expecting one entry on disk")
+	}
+
+	// Moved back to memory, pushing out b
+	if v, ok := c.Get("a"); !ok || v != "va" {
+		t.Error("Expecting hit")
+	}
+	if _, ok := c.mem.GetQuiet("a"); !ok {
+		t.Error("Expecting a in memory")
+	}
+	if _, ok := c.mem.GetQuiet("b"); ok || c.disk.Len() != 1 {
+		t.Error("Expecting b on disk")
+	}
+
+	// GetQuiet leaves it on disk
+	if v, _ := c.GetQuiet("b"); v != "vb" || c.disk.Len() != 1 {
+		t.Error("Expecting b to stay on disk")
+	}
+
+	// Overwriting drops the copy on disk
+	c.Set("b", "vb2", time.Time{})
+	if v, _ := c.Get("b"); v != "vb2" {
+		t.Error("Expecting new value")
+	}
+
+	if v, ok := c.Del("b"); !ok || v != "vb2" {
+		t.Error("Expecting delete")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expecting miss")
+	}
+
+	s := c.Stats()
+	if s.Spills != 3 || s.DiskHits != 2 || s.Misses != 1 {
+		t.Errorf("Expecting different stats %+v", s)
+	}
+
+	if c.Clear() != 2 || c.Len() != 0 {
+		t.Error("Expecting empty cache")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+	c := newTiered(t, 1, Options{})
+	defer c.Close()
+
+	now := time.Now()
+	c.Set("a", "va", now.Add(time.Duration(1*time.Second)))
+	c.Set("b", "vb", now.Add(time.Duration(1*time.Second)))
+	c.Set("c", "vc", time.Time{})
+
+	// Expiry is kept on disk
+	if _, ok := c.GetNotStaleNow("a", now); !ok {
+		t.Error("Expecting hit")
+	}
+	if _, ok := c.GetNotStaleNow("b", now.Add(time.Duration(2*time.Second))); ok {
+		t.Error("Expecting miss")
+	}
+	if c.ExpireNow(now.Add(time.Duration(2*time.Second))) != 1 || c.Len() != 1 {
+		t.Error("Expecting a to expire")
+	}
+	if v, _ := c.Get("c"); v != "vc" {
+		t.Error("Expecting hit")
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	t.Parallel()
+	d, err := NewDiskStore(filepath.Join(t.TempDir(), "log"), 10, nil, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := d.Set(fmt.Sprintf("k%d", i%20), i, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, garbage := d.Size()
+	if d.Len() != 10 || garbage*2 > size {
+		t.Errorf("Expecting compacted log %d %d", size, garbage)
+	}
+	for i := 80; i < 100; i++ {
+		v, _, ok, err := d.Get(fmt.Sprintf("k%d", i%20))
+		if i < 90 && ok {
+			t.Error("Expecting miss")
+		}
+		if i >= 90 && (!ok || err != nil || v != i) {
+			t.Errorf("Expecting hit %v %v", v, err)
+		}
+	}
+
+	if err := d.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if size, garbage := d.Size(); garbage != 0 || size == 0 {
+		t.Error("Expecting no garbage")
+	}
+	if v, _, _, _ := d.Get("k15"); v != 95 {
+		t.Error("Expecting hit after compaction")
+	}
+}
+
+func TestDiskStoreClock(t *testing.T) {
+	t.Parallel()
+	clock := lrucache.NewManualClock(time.Now().Add(time.Hour))
+	d, err := NewDiskStore(filepath.Join(t.TempDir(), "log"), 2, nil, clock, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// a expires on the clock, not on real time, so it goes first
+	d.Set("a", "va", clock.Now().Add(time.Second))
+	d.Set("b", "vb", time.Time{})
+	d.Get("a")
+	clock.Advance(2 * time.Second)
+	d.Set("c", "vc", time.Time{})
+	if _, _, ok, _ := d.Get("b"); !ok || d.Len() != 2 {
+		t.Error("Expecting a to be evicted")
+	}
+}